@@ -0,0 +1,23 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/raziel-aleman/go-todo-app/internal/auth"
+)
+
+const keygenUsage = `usage: api keygen
+
+prints a new key for signing and encrypting session cookies, to put first in
+SESSION_KEYS or in the file SESSION_KEYS_FILE names`
+
+// keygen runs the keygen subcommand with the given arguments.
+func keygen(args []string) error {
+	if len(args) != 0 {
+		return errors.New(keygenUsage)
+	}
+
+	fmt.Println(auth.GenerateKey())
+	return nil
+}
@@ -38,18 +38,36 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/raziel-aleman/go-todo-app/internal/auth"
 	"github.com/raziel-aleman/go-todo-app/internal/server"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := keygen(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	auth.NewAuth()
 
-	server := server.NewServer()
+	server, err := server.NewServer()
+	if err != nil {
+		panic(fmt.Sprintf("cannot start server: %s", err))
+	}
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
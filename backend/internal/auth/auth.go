@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
@@ -23,7 +22,19 @@ const (
 	SessionName = "user_session"
 )
 
-var key = securecookie.GenerateRandomKey(64)
+// ErrNoSession is returned when a request has no valid session.
+var ErrNoSession = errors.New("session is not valid, please login")
+
+// Key of the id of the logged in user in the values of a session
+const userIdValue = "userId"
+
+// Keys protecting session cookies, newest first. NewAuth loads the configured
+// ones; until then a random key is used, which no other instance or restart
+// of the server knows.
+var keyRing = []Key{GenerateKey()}
+
+// How many sessions a user can have, set by NewAuth
+var sessionPolicy = Unlimited
 
 func NewAuth() {
 	err := godotenv.Load()
@@ -31,106 +42,90 @@ func NewAuth() {
 		log.Fatal("Error loading .env files")
 	}
 
+	configuredKeys, err := LoadKeys()
+	if err != nil {
+		log.Fatal("Error loading session keys: ", err)
+	}
+	if len(configuredKeys) == 0 {
+		log.Println("No SESSION_KEYS or SESSION_KEYS_FILE set, users will be logged out when the server restarts")
+	} else {
+		keyRing = configuredKeys
+	}
+
+	sessionPolicy, err = LoadSessionPolicy()
+	if err != nil {
+		log.Fatal("Error loading session policy: ", err)
+	}
+
 	githubClientId := os.Getenv("GITHUB_CLIENT_ID")
 	githubClientSecret := os.Getenv("GITHUB_CLIENT_SECRET")
 	githubCallbackUrl := os.Getenv("GITHUB_CALLBACK_URL")
 
-	store := sessions.NewCookieStore([]byte(key))
-	store.MaxAge(MaxAge)
-
-	store.Options.Path = "/"
-	store.Options.HttpOnly = HttpOnly
-	store.Options.Secure = IsProd
-	store.Options.SameSite = http.SameSiteNoneMode
-
-	gothic.Store = store
+	// Sign in state only lives until the provider redirects back, so it stays
+	// in a cookie. Logged in sessions are kept in the database by Store.
+	gothic.Store = newStore()
 
 	goth.UseProviders(
 		github.New(githubClientId, githubClientSecret, githubCallbackUrl),
 	)
 }
 
-func StoreUserSession(w http.ResponseWriter, r *http.Request, user goth.User) (string, error) {
-
-	store := sessions.NewCookieStore([]byte(key))
+/* Returns a cookie store that signs and encrypts cookies with the newest key and accepts cookies made with any of the keys. */
+func newStore() *sessions.CookieStore {
+	store := sessions.NewCookieStore(keyPairs(keyRing)...)
 	store.MaxAge(MaxAge)
 	store.Options.Path = "/"
 	store.Options.HttpOnly = HttpOnly
 	store.Options.Secure = IsProd
 	store.Options.SameSite = http.SameSiteNoneMode
 
-	userSession, err := store.Get(r, SessionName)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return "", err
-	}
-
-	sessionId := uuid.NewString()
-	userSession.Values["sessionId"] = sessionId
+	return store
+}
 
-	err = userSession.Save(r, w)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return "", err
+// StoreUserSession starts a new session for a user, ending the session the
+// request had before.
+func (st *Store) StoreUserSession(w http.ResponseWriter, r *http.Request, userId string) error {
+	if previous, err := st.Get(r, SessionName); err == nil {
+		if err := st.delete(r, previous); err != nil {
+			return err
+		}
 	}
 
-	return sessionId, nil
-}
+	session := st.newSession(SessionName)
+	session.Values[userIdValue] = userId
 
-func GetUserSession(r *http.Request) (string, error) {
-	store := sessions.NewCookieStore([]byte(key))
+	return session.Save(r, w)
+}
 
-	userSession, err := store.Get(r, SessionName)
+// UserSession returns the session of the request and the id of the user it
+// belongs to. It fails with ErrNoSession when the request has no valid
+// session.
+func (st *Store) UserSession(r *http.Request) (*sessions.Session, string, error) {
+	session, err := st.Get(r, SessionName)
+	var cookieErr securecookie.Error
+	if errors.As(err, &cookieErr) && cookieErr.IsDecode() {
+		return nil, "", ErrNoSession
+	}
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
 
-	if sessionId, ok := userSession.Values["sessionId"]; ok {
-		return sessionId.(string), nil
-	} else {
-		return "", errors.New("no session id found in request")
+	userId, _ := session.Values[userIdValue].(string)
+	if session.IsNew || userId == "" {
+		return nil, "", ErrNoSession
 	}
 
-	//return userSession.Values["sessionId"].(string), nil
+	return session, userId, nil
 }
 
-func RequireAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		sessionId, err := GetUserSession(r)
-		if err != nil {
-			log.Println(err, "User is not authenticated!")
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		//log.Printf("session id found: %s", sessionId)
-
-		parsedUUID, err := uuid.Parse(sessionId)
-
-		if err != nil {
-			log.Println(err, "User is not authenticated!")
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-		log.Printf("session id parsed as UUID: %s", parsedUUID)
+// RemoveUserSession ends the session of the request and expires its cookie.
+func (st *Store) RemoveUserSession(w http.ResponseWriter, r *http.Request) error {
+	// A session that cannot be read is still removed from the browser
+	session, _ := st.Get(r, SessionName)
+	session.Options.MaxAge = -1
 
-		handlerFunc(w, r)
-	}
-}
-
-func RemoveUserSession(w http.ResponseWriter, r *http.Request) error {
-	store := sessions.NewCookieStore([]byte(key))
-	store.MaxAge(-1)
-	store.Options.Path = "/"
-	store.Options.HttpOnly = HttpOnly
-	store.Options.Secure = IsProd
-	store.Options.SameSite = http.SameSiteNoneMode
-
-	session, _ := store.Get(r, SessionName)
-
-	err := session.Save(r, w)
-	if err != nil {
+	if err := session.Save(r, w); err != nil {
 		log.Println("could not expire client session")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return err
 	}
 
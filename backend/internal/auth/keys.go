@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Lengths of the two halves of a Key, in bytes. A 32 byte block key selects
+// AES-256.
+const (
+	hashKeyLength  = 64
+	blockKeyLength = 32
+)
+
+// Key is a pair of secrets protecting session cookies: HashKey signs a cookie
+// and BlockKey encrypts it.
+type Key struct {
+	HashKey  []byte
+	BlockKey []byte
+}
+
+// GenerateKey returns a new random Key.
+func GenerateKey() Key {
+	return Key{
+		HashKey:  securecookie.GenerateRandomKey(hashKeyLength),
+		BlockKey: securecookie.GenerateRandomKey(blockKeyLength),
+	}
+}
+
+// String encodes the key the way it is written in SESSION_KEYS or a key file.
+func (k Key) String() string {
+	return base64.RawURLEncoding.EncodeToString(append(append([]byte{}, k.HashKey...), k.BlockKey...))
+}
+
+// ParseKey decodes a key written by Key.String.
+func ParseKey(s string) (Key, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != hashKeyLength+blockKeyLength {
+		return Key{}, errors.New("session key must be generated with the keygen command")
+	}
+
+	return Key{HashKey: b[:hashKeyLength], BlockKey: b[hashKeyLength:]}, nil
+}
+
+// LoadKeys returns the keys configured in SESSION_KEYS, a comma separated
+// list, or else in the file SESSION_KEYS_FILE names, one key per line where
+// empty lines and lines starting with # are skipped. Keys are listed newest
+// first: the first one protects new cookies and the others only verify
+// cookies made before it was added, so keys can be rotated without logging
+// anyone out. It returns no keys when neither is set.
+func LoadKeys() ([]Key, error) {
+	var lines []string
+	source := "SESSION_KEYS"
+	if list := os.Getenv("SESSION_KEYS"); list != "" {
+		lines = strings.Split(list, ",")
+	} else if path := os.Getenv("SESSION_KEYS_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		lines = strings.Split(string(content), "\n")
+		source = path
+	} else {
+		return nil, nil
+	}
+
+	var keys []Key
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s, entry %d: %w", source, i+1, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s has no keys", source)
+	}
+	return keys, nil
+}
+
+/* Flattens keys into the hash and block key pairs a cookie store takes, newest first. */
+func keyPairs(keys []Key) [][]byte {
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key.HashKey, key.BlockKey)
+	}
+	return pairs
+}
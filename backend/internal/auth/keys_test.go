@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+)
+
+/* Protects cookies with keys until the test ends. */
+func useKeys(t *testing.T, keys ...Key) {
+	t.Helper()
+
+	previous := keyRing
+	keyRing = keys
+	t.Cleanup(func() { keyRing = previous })
+}
+
+/* Returns a database with the given users. */
+func newDB(t *testing.T, userIds ...string) database.Service {
+	t.Helper()
+
+	db := database.NewMemory()
+	for _, userId := range userIds {
+		if err := db.SaveUser(context.Background(), goth.User{UserID: userId, Name: userId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+/* Logs a user in with a store and returns the session cookie it set. */
+func login(t *testing.T, st *Store, userId string) *http.Cookie {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := st.StoreUserSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), userId); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("login set cookies %v, want one", cookies)
+	}
+	return cookies[0]
+}
+
+/* Returns the user a store finds logged in with a cookie. */
+func loggedIn(st *Store, cookie *http.Cookie) (string, error) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	_, userId, err := st.UserSession(req)
+	return userId, err
+}
+
+func TestKeyRotation(t *testing.T) {
+	db := newDB(t, "u1")
+	oldKey, newKey := GenerateKey(), GenerateKey()
+
+	useKeys(t, oldKey)
+	oldCookie := login(t, NewStore(db), "u1")
+
+	// Cookies made with the old key still work once a new key is put in front
+	useKeys(t, newKey, oldKey)
+	rotated := NewStore(db)
+	if userId, err := loggedIn(rotated, oldCookie); err != nil || userId != "u1" {
+		t.Fatalf("cookie of the old key after rotation: %q, %v", userId, err)
+	}
+	newCookie := login(t, rotated, "u1")
+
+	// New cookies are made with the new key alone
+	useKeys(t, newKey)
+	withoutOldKey := NewStore(db)
+	if userId, err := loggedIn(withoutOldKey, newCookie); err != nil || userId != "u1" {
+		t.Fatalf("cookie of the new key: %q, %v", userId, err)
+	}
+
+	// Removing a key logs out the cookies made with it
+	if _, err := loggedIn(withoutOldKey, oldCookie); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("cookie of a removed key: %v, want ErrNoSession", err)
+	}
+	useKeys(t, GenerateKey())
+	if _, err := loggedIn(NewStore(db), newCookie); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("cookie of an unknown key: %v, want ErrNoSession", err)
+	}
+}
+
+func TestSignInStateKeyRotation(t *testing.T) {
+	oldKey, newKey := GenerateKey(), GenerateKey()
+
+	useKeys(t, oldKey)
+	store := newStore()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := store.New(req, "state")
+	session.Values["state"] = "abc"
+	if err := store.Save(req, rec, session); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+
+	read := func() (any, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookie)
+		session, err := newStore().New(req, "state")
+		return session.Values["state"], err
+	}
+
+	useKeys(t, newKey, oldKey)
+	if state, err := read(); err != nil || state != "abc" {
+		t.Fatalf("state of the old key after rotation: %v, %v", state, err)
+	}
+
+	useKeys(t, newKey)
+	if _, err := read(); err == nil {
+		t.Fatal("state of a removed key was accepted")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := GenerateKey()
+	parsed, err := ParseKey(key.String())
+	if err != nil || !slices.Equal(parsed.HashKey, key.HashKey) || !slices.Equal(parsed.BlockKey, key.BlockKey) {
+		t.Fatalf("ParseKey(key.String()) = %v, %v", parsed, err)
+	}
+
+	for _, bad := range []string{"", "not base64!", key.String()[:20], key.String() + "AAAA", "c2hvcnQ"} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	first, second := GenerateKey(), GenerateKey()
+	path := filepath.Join(t.TempDir(), "keys")
+	writeFile := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		keys     string
+		file     string
+		fileKeys string
+		want     []Key
+		err      string
+	}{
+		{name: "nothing set"},
+		{name: "one key", keys: first.String(), want: []Key{first}},
+		{name: "newest first", keys: first.String() + ", " + second.String(), want: []Key{first, second}},
+		{name: "bad key", keys: first.String() + ",nonsense", err: "SESSION_KEYS, entry 2"},
+		{name: "only separators", keys: " , ", err: "SESSION_KEYS has no keys"},
+		{name: "file", file: path, fileKeys: "# rotated in March\n" + first.String() + "\n\n" + second.String() + "\n", want: []Key{first, second}},
+		{name: "list before file", keys: second.String(), file: path, fileKeys: first.String(), want: []Key{second}},
+		{name: "bad key in file", file: path, fileKeys: "# comment\n" + first.String()[1:], err: path + ", entry 2"},
+		{name: "file of comments", file: path, fileKeys: "# no keys yet\n", err: path + " has no keys"},
+		{name: "missing file", file: path + ".missing", err: "no such file"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEYS", test.keys)
+			t.Setenv("SESSION_KEYS_FILE", test.file)
+			writeFile(test.fileKeys)
+
+			keys, err := LoadKeys()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error %v, want one mentioning %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != len(test.want) {
+				t.Fatalf("%d keys, want %d", len(keys), len(test.want))
+			}
+			for i := range keys {
+				if keys[i].String() != test.want[i].String() {
+					t.Errorf("key %d is not the configured one", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SessionPolicy limits how many devices a user can be logged in on at once.
+// When a login goes over the limit, the user's oldest sessions end.
+type SessionPolicy struct {
+	// Most sessions a user can have, zero for no limit
+	MaxSessions int
+}
+
+// The policies SESSION_POLICY names
+var (
+	// Unlimited lets a user log in on any number of devices.
+	Unlimited = SessionPolicy{}
+	// Single logs a user out everywhere else when they log in.
+	Single = SessionPolicy{MaxSessions: 1}
+)
+
+// ParseSessionPolicy parses a policy written as unlimited, single or max:N.
+func ParseSessionPolicy(s string) (SessionPolicy, error) {
+	switch s {
+	case "unlimited":
+		return Unlimited, nil
+	case "single":
+		return Single, nil
+	}
+
+	if n, ok := strings.CutPrefix(s, "max:"); ok {
+		max, err := strconv.Atoi(n)
+		if err == nil && max > 0 {
+			return SessionPolicy{MaxSessions: max}, nil
+		}
+	}
+
+	return SessionPolicy{}, fmt.Errorf("session policy %q must be unlimited, single or max:N with N above zero", s)
+}
+
+// LoadSessionPolicy returns the policy set in SESSION_POLICY, Unlimited when
+// it is not set.
+func LoadSessionPolicy() (SessionPolicy, error) {
+	s := os.Getenv("SESSION_POLICY")
+	if s == "" {
+		return Unlimited, nil
+	}
+	return ParseSessionPolicy(s)
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseSessionPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		want   SessionPolicy
+		valid  bool
+	}{
+		{"unlimited", Unlimited, true},
+		{"single", Single, true},
+		{"max:1", SessionPolicy{MaxSessions: 1}, true},
+		{"max:5", SessionPolicy{MaxSessions: 5}, true},
+		{"max:0", SessionPolicy{}, false},
+		{"max:-2", SessionPolicy{}, false},
+		{"max:", SessionPolicy{}, false},
+		{"max:two", SessionPolicy{}, false},
+		{"max5", SessionPolicy{}, false},
+		{"Single", SessionPolicy{}, false},
+		{"", SessionPolicy{}, false},
+	} {
+		got, err := ParseSessionPolicy(test.policy)
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("ParseSessionPolicy(%q) = %+v, %v, want %+v", test.policy, got, err, test.want)
+		}
+	}
+}
+
+func TestLoadSessionPolicy(t *testing.T) {
+	t.Setenv("SESSION_POLICY", "")
+	if policy, err := LoadSessionPolicy(); err != nil || policy != Unlimited {
+		t.Errorf("policy without SESSION_POLICY = %+v, %v, want unlimited", policy, err)
+	}
+
+	t.Setenv("SESSION_POLICY", "max:3")
+	if policy, err := LoadSessionPolicy(); err != nil || policy.MaxSessions != 3 {
+		t.Errorf("policy max:3 = %+v, %v", policy, err)
+	}
+
+	t.Setenv("SESSION_POLICY", "many")
+	if _, err := LoadSessionPolicy(); err == nil {
+		t.Error("invalid SESSION_POLICY was accepted")
+	}
+}
+
+func TestSessionPolicyEvictsOldestSessions(t *testing.T) {
+	for _, test := range []struct {
+		policy SessionPolicy
+		// Which of four logins in a row are still logged in afterwards
+		want [4]bool
+	}{
+		{Unlimited, [4]bool{true, true, true, true}},
+		{Single, [4]bool{false, false, false, true}},
+		{SessionPolicy{MaxSessions: 2}, [4]bool{false, false, true, true}},
+	} {
+		db := newDB(t, "u1", "u2")
+		st := NewStore(db)
+		st.Policy = test.policy
+
+		// Another user's sessions do not count towards the limit
+		other := login(t, st, "u2")
+		var cookies [4]*http.Cookie
+		for i := range cookies {
+			cookies[i] = login(t, st, "u1")
+		}
+
+		for i, cookie := range cookies {
+			_, err := loggedIn(st, cookie)
+			if active := err == nil; active != test.want[i] {
+				t.Errorf("policy %+v: login %d logged in = %v, %v", test.policy, i+1, active, err)
+			} else if err != nil && !errors.Is(err, ErrNoSession) {
+				t.Errorf("policy %+v: login %d: %v", test.policy, i+1, err)
+			}
+		}
+		if _, err := loggedIn(st, other); err != nil {
+			t.Errorf("policy %+v: logins of u1 ended the session of u2: %v", test.policy, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+const (
+	// Length of session tokens in bytes
+	tokenLength = 32
+	// How long a session goes without recording that it was seen again
+	touchInterval = time.Minute
+)
+
+// Store is a sessions.Store keeping sessions in the database. The cookie of a
+// session only carries a random token, signed and encrypted with the key
+// ring, and the database only stores the token's hash. The ID of a session
+// is the id of its record, so it can be listed and revoked without revealing
+// the token.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// How many sessions a user can have at once
+	Policy SessionPolicy
+
+	db database.Service
+}
+
+// NewStore returns a Store keeping sessions in db, protecting cookies with
+// the keys and limiting sessions with the policy NewAuth loaded.
+func NewStore(db database.Service) *Store {
+	codecs := securecookie.CodecsFromPairs(keyPairs(keyRing)...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(MaxAge)
+		}
+	}
+
+	return &Store{
+		Codecs: codecs,
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   MaxAge,
+			HttpOnly: HttpOnly,
+			Secure:   IsProd,
+			SameSite: http.SameSiteNoneMode,
+		},
+		Policy: sessionPolicy,
+		db:     db,
+	}
+}
+
+// Get returns the session called name of the request, loading it from the
+// database only once per request.
+func (st *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(st, name)
+}
+
+// New returns the session the cookie called name refers to, or a new session
+// when there is no such cookie or its session expired or was revoked. Loading
+// a session records the address and browser it is used from.
+func (st *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := st.newSession(name)
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, st.Codecs...); err != nil {
+		return session, err
+	}
+
+	record, err := st.db.GetSession(r.Context(), hashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if err := decodeValues(record.Data, &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = strconv.Itoa(record.ID)
+	session.IsNew = false
+
+	st.touch(r, record)
+	return session, nil
+}
+
+// Save stores the session and, for a new session, sets the cookie with its
+// token. New sessions need the id of the user they belong to in their
+// values, and end the user's oldest sessions when the policy's limit is
+// reached. A negative MaxAge deletes the session and its cookie.
+func (st *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if err := st.delete(r, session); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userId, _ := session.Values[userIdValue].(string)
+
+	data, err := encodeValues(session.Values)
+	if err != nil {
+		return err
+	}
+
+	if id, err := strconv.Atoi(session.ID); err == nil {
+		return st.db.UpdateSession(r.Context(), m.Session{
+			ID:        id,
+			UserId:    userId,
+			Data:      data,
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+	}
+
+	if userId == "" {
+		return errors.New("a new session needs the id of its user")
+	}
+
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = MaxAge
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(tokenLength))
+	encoded, err := securecookie.EncodeMulti(session.Name(), token, st.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	id, err := st.db.CreateSession(r.Context(), m.Session{
+		TokenHash: hashToken(token),
+		UserId:    userId,
+		Data:      data,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Device:    deviceName(r.UserAgent()),
+		ExpiresAt: time.Now().Add(time.Duration(maxAge) * time.Second),
+	}, st.Policy.MaxSessions)
+	if err != nil {
+		return err
+	}
+	session.ID = strconv.Itoa(id)
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+/* Returns an empty session with the store's options. */
+func (st *Store) newSession(name string) *sessions.Session {
+	session := sessions.NewSession(st, name)
+	options := *st.Options
+	session.Options = &options
+	session.IsNew = true
+	return session
+}
+
+/* Deletes the record of a session, if it has one that still exists. */
+func (st *Store) delete(r *http.Request, session *sessions.Session) error {
+	id, err := strconv.Atoi(session.ID)
+	if err != nil {
+		return nil
+	}
+
+	userId, _ := session.Values[userIdValue].(string)
+	err = st.db.DeleteSession(r.Context(), id, userId)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+/* Records that a session was seen, unless it was seen recently from the same address and browser. Failing to is only logged. */
+func (st *Store) touch(r *http.Request, record m.Session) {
+	ip, userAgent := clientIP(r), r.UserAgent()
+	if time.Since(record.LastSeenAt) < touchInterval && record.IP == ip && record.UserAgent == userAgent {
+		return
+	}
+
+	record.IP = ip
+	record.UserAgent = userAgent
+	if err := st.db.UpdateSession(r.Context(), record); err != nil {
+		log.Println("could not record session activity:", err)
+	}
+}
+
+/* Returns the hash a session token is stored as. */
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/* Returns the address of the client making a request. */
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/* Encodes the values of a session for storage. */
+func encodeValues(values map[any]any) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+/* Decodes stored session values into values. */
+func decodeValues(data string, values *map[any]any) error {
+	if data == "" {
+		return nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(values)
+}
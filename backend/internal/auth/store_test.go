@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+)
+
+func TestCookieCarriesOnlyToken(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t, "u1")
+	st := NewStore(db)
+	cookie := login(t, st, "u1")
+
+	// The cookie decodes to the token and nothing else
+	var token string
+	if err := securecookie.DecodeMulti(SessionName, cookie.Value, &token, st.Codecs...); err != nil {
+		t.Fatalf("cookie does not hold a token: %v", err)
+	}
+	if len(token) < tokenLength || strings.Contains(cookie.Value, "u1") {
+		t.Fatalf("cookie token %q", token)
+	}
+
+	// The database only knows the token's hash
+	sessions, err := db.GetSessions(ctx, "u1")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions = %+v, %v", sessions, err)
+	}
+	record := sessions[0]
+	if record.TokenHash != hashToken(token) || strings.Contains(record.TokenHash+record.Data, token) {
+		t.Errorf("stored session %+v holds the token", record)
+	}
+	if _, err := db.GetSession(ctx, token); err == nil {
+		t.Error("session found by its token rather than its hash")
+	}
+	if found, err := db.GetSession(ctx, hashToken(token)); err != nil || found.ID != record.ID {
+		t.Errorf("session by hash = %+v, %v", found, err)
+	}
+}
+
+func TestStoreSessions(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t, "u1")
+	st := NewStore(db)
+
+	// Without a cookie the session is new and no one is logged in
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if session, err := st.New(req, SessionName); err != nil || !session.IsNew {
+		t.Fatalf("session without a cookie = %+v, %v", session, err)
+	}
+	if _, _, err := st.UserSession(req); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("user session without a cookie: %v, want ErrNoSession", err)
+	}
+
+	// Values are kept between requests
+	cookie := login(t, st, "u1")
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	session, userId, err := st.UserSession(req)
+	if err != nil || userId != "u1" {
+		t.Fatalf("user session = %q, %v", userId, err)
+	}
+	session.Values["theme"] = "dark"
+	if err := session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	if session, _, err := st.UserSession(req); err != nil || session.Values["theme"] != "dark" {
+		t.Fatalf("values after saving = %v, %v", session.Values, err)
+	}
+
+	// Logging in again from the same browser ends the session it had
+	rec := httptest.NewRecorder()
+	if err := st.StoreUserSession(rec, req, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loggedIn(st, cookie); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("session replaced by a new login: %v, want ErrNoSession", err)
+	}
+	cookie = rec.Result().Cookies()[0]
+
+	// Logging out deletes the session and expires the cookie
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	if err := st.RemoveUserSession(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if expired := rec.Result().Cookies(); len(expired) != 1 || expired[0].MaxAge >= 0 {
+		t.Errorf("cookies after logout = %v, want the session cookie expired", expired)
+	}
+	if sessions, err := db.GetSessions(ctx, "u1"); err != nil || len(sessions) != 0 {
+		t.Fatalf("sessions after logout = %+v, %v", sessions, err)
+	}
+	if _, err := loggedIn(st, cookie); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("session after logout: %v, want ErrNoSession", err)
+	}
+}
+
+func TestRevokedSession(t *testing.T) {
+	ctx := context.Background()
+	db := newDB(t, "u1")
+	st := NewStore(db)
+	cookie := login(t, st, "u1")
+
+	sessions, err := db.GetSessions(ctx, "u1")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions = %+v, %v", sessions, err)
+	}
+	if err := db.DeleteSession(ctx, sessions[0].ID, "u1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cookie is still valid, the session it names is not
+	if _, err := loggedIn(st, cookie); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("revoked session: %v, want ErrNoSession", err)
+	}
+
+	// Requests record where a session is used from
+	cookie = login(t, st, "u1")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:4567"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	req.AddCookie(cookie)
+	session, _, err := st.UserSession(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = db.GetSessions(ctx, "u1")
+	if err != nil || len(sessions) != 1 || strconv.Itoa(sessions[0].ID) != session.ID {
+		t.Fatalf("sessions = %+v, %v", sessions, err)
+	}
+	if sessions[0].IP != "203.0.113.7" || !strings.Contains(sessions[0].UserAgent, "Firefox") {
+		t.Errorf("session used from %q with %q", sessions[0].IP, sessions[0].UserAgent)
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+func TestCanceledContext(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		saveUsers(t, db, "u1")
+		id := createTodo(t, db, m.NewTodo{Title: "todo"}, "u1")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := db.GetAll(ctx, "u1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("GetAll: %v, want context.Canceled", err)
+		}
+		if _, err := db.Create(ctx, m.NewTodo{Title: "canceled"}, "u1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Create: %v, want context.Canceled", err)
+		}
+		if err := db.Edit(ctx, id, m.NewTodo{Title: "canceled"}, "u1", 0); !errors.Is(err, context.Canceled) {
+			t.Errorf("Edit: %v, want context.Canceled", err)
+		}
+		if err := db.WithTx(ctx, func(tx Service) error { return nil }); !errors.Is(err, context.Canceled) {
+			t.Errorf("WithTx: %v, want context.Canceled", err)
+		}
+
+		todos, err := db.GetAll(context.Background(), "u1")
+		if err != nil || len(todos) != 1 || todos[0].Title != "todo" {
+			t.Fatalf("todos after canceled calls = %+v, %v", todos, err)
+		}
+	})
+}
+
+func TestExpiredDeadline(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		saveUsers(t, db, "u1")
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		if _, err := db.GetAll(ctx, "u1"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("GetAll: %v, want context.DeadlineExceeded", err)
+		}
+		if _, err := db.Create(ctx, m.NewTodo{Title: "late"}, "u1"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Create: %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+func TestCancelDuringTransaction(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		saveUsers(t, db, "u1")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// A client going away halfway through undoes what was done so far
+		err := db.WithTx(ctx, func(tx Service) error {
+			if _, err := tx.Create(ctx, m.NewTodo{Title: "first"}, "u1"); err != nil {
+				return err
+			}
+			cancel()
+			_, err := tx.Create(ctx, m.NewTodo{Title: "second"}, "u1")
+			return err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled transaction: %v, want context.Canceled", err)
+		}
+
+		todos, err := db.GetAll(context.Background(), "u1")
+		if err != nil || len(todos) != 0 {
+			t.Fatalf("todos after canceled transaction = %v, %v", todoIds(todos), err)
+		}
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	s := openSQL(t, filepath.Join(t.TempDir(), "test.db")).(*service)
+	saveUsers(t, s, "u1")
+	s.timeout = 50 * time.Millisecond
+
+	// Counts far enough to take seconds unless the timeout interrupts it
+	ctx, cancel := s.withTimeout(context.Background())
+	defer cancel()
+	start := time.Now()
+	var n int
+	err := s.db.QueryRow(ctx, `WITH RECURSIVE counter(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM counter)
+		SELECT COUNT(*) FROM counter;`).Scan(&n)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow query: %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("slow query ran for %v after its timeout", elapsed)
+	}
+
+	// The timeout applies to every call, whatever context it is given
+	s.timeout = time.Nanosecond
+	if _, err := s.GetAll(context.Background(), "u1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetAll past the timeout: %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestQueryTimeoutSetting(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":      defaultQueryTimeout,
+		"250ms": 250 * time.Millisecond,
+		"2s":    2 * time.Second,
+		"0":     0,
+		"-1s":   defaultQueryTimeout,
+		"soon":  defaultQueryTimeout,
+	} {
+		t.Setenv("DB_QUERY_TIMEOUT", value)
+		if got := queryTimeout(); got != want {
+			t.Errorf("DB_QUERY_TIMEOUT=%q gives %v, want %v", value, got, want)
+		}
+	}
+}
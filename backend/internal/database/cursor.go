@@ -0,0 +1,71 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// ErrInvalidFilter is returned for unknown sort keys and cursors that do not belong to the query.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// SQL expressions todos can be sorted by. Missing dates sort after every
+// date so the expressions never compare NULL.
+var sortExpressions = map[string]string{
+	m.SortPosition: "position",
+	m.SortPriority: "priority",
+	m.SortDueAt:    "COALESCE(dueAt, '9999-12-31 23:59:59')",
+	m.SortStartAt:  "COALESCE(startAt, '9999-12-31 23:59:59')",
+	m.SortTitle:    "LOWER(title)",
+	m.SortCreated:  "id",
+}
+
+// cursor marks the last todo of a page. It is handed to clients as an opaque
+// token so the encoding can change without breaking them.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value any    `json:"v"`
+	ID    int    `json:"i"`
+}
+
+/* Encodes a cursor as an opaque URL safe token. */
+func encodeCursor(c cursor) string {
+	// Text columns may be scanned as bytes, which would otherwise be encoded as base64
+	if b, ok := c.Value.([]byte); ok {
+		c.Value = string(b)
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+/* Decodes a token created by encodeCursor. */
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		return c, err
+	}
+
+	// Keep integer sort values as integers so they compare exactly
+	if n, ok := c.Value.(json.Number); ok {
+		if strings.ContainsAny(n.String(), ".eE") {
+			c.Value, err = n.Float64()
+		} else {
+			c.Value, err = n.Int64()
+		}
+	}
+	return c, err
+}
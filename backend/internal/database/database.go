@@ -3,130 +3,305 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/markbates/goth"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/raziel-aleman/go-todo-app/internal/migrations"
 	m "github.com/raziel-aleman/go-todo-app/internal/models"
 )
 
-// Service represents a service that interacts with a database.
+// Service represents a service that interacts with a database. Every method
+// but Close takes the context of the request it serves: when the request is
+// canceled or the query timeout passes, the database work is abandoned and
+// the context's error is returned.
 type Service interface {
 	// Health returns a map of health status information.
 	// The keys and values in the map are service-specific.
-	Health() map[string]string
+	Health(context.Context) map[string]string
 
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
 	Close() error
 
-	GetAll(string) ([]m.Todo, error)
+	GetAll(context.Context, string) ([]m.Todo, error)
 
-	MarkDone(int64) error
+	Get(context.Context, int, string) (m.Todo, error)
 
-	Create(m.NewTodo, string) (int, error)
+	GetFiltered(context.Context, string, m.TodoFilter) ([]m.Todo, string, error)
 
-	Edit(int, m.NewTodo, string) error
+	// Deprecated: MarkDone toggles the done state, so repeated requests undo
+	// each other. Use SetDone.
+	MarkDone(context.Context, int64, string, bool, int) error
 
-	SaveUser(goth.User, string) (string, error)
+	SetDone(context.Context, int64, string, bool, bool, int) error
 
-	IsSessionIdValid(string) (string, error)
+	GetChildren(context.Context, int, string) ([]m.Todo, error)
+
+	SetParent(context.Context, int, *int, string, int) error
+
+	SkipOccurrence(context.Context, int, string, int) error
+
+	EndSeries(context.Context, int, string, int) error
+
+	Search(context.Context, string, string, int) ([]m.SearchResult, error)
+
+	Create(context.Context, m.NewTodo, string) (int, error)
+
+	Edit(context.Context, int, m.NewTodo, string, int) error
+
+	Move(context.Context, int, m.MoveTodo, string, int) error
+
+	Delete(context.Context, int, string, int) error
+
+	GetTrash(context.Context, string) ([]m.Todo, error)
+
+	Restore(context.Context, int, string) error
+
+	PurgeTrash(context.Context, int) (int64, error)
+
+	GetTags(context.Context, string) ([]m.Tag, error)
+
+	CreateTag(context.Context, m.NewTag, string) (int, error)
+
+	EditTag(context.Context, int, m.NewTag, string) error
+
+	DeleteTag(context.Context, int, string) error
+
+	GetLists(context.Context, string, bool) ([]m.List, error)
+
+	CreateList(context.Context, m.NewList, string) (int, error)
+
+	EditList(context.Context, int, m.NewList, string) error
+
+	DeleteList(context.Context, int, string) error
+
+	MoveToList(context.Context, int, int, string, int) error
+
+	SaveUser(context.Context, goth.User) error
+
+	// CreateSession stores a new session. When the limit it is given is
+	// positive, the user's oldest sessions beyond that many are ended.
+	CreateSession(context.Context, m.Session, int) (int, error)
+
+	// GetSession returns the session with a token hash, or ErrNotFound when
+	// there is none or it expired.
+	GetSession(context.Context, string) (m.Session, error)
+
+	UpdateSession(context.Context, m.Session) error
+
+	GetSessions(context.Context, string) ([]m.Session, error)
+
+	DeleteSession(context.Context, int, string) error
+
+	DeleteOtherSessions(context.Context, int, string) (int64, error)
+
+	PurgeSessions(context.Context) (int64, error)
+
+	GetTimeZone(context.Context, string) (string, error)
+
+	SetTimeZone(context.Context, string, string) error
+
+	// WithTx runs fn as a unit of work: the calls fn makes on the Service it
+	// is given are committed together when fn returns nil and rolled back
+	// when it returns an error.
+	WithTx(context.Context, func(Service) error) error
 }
 
+// ErrNotFound is returned when a todo or tag does not exist or is not owned by the requesting user.
+var ErrNotFound = errors.New("not found")
+
 type service struct {
-	db *sql.DB
+	// The connection pool, and the same pool running queries in its dialect
+	pool *sql.DB
+	db   conn
+
+	// Whether the FTS5 search index is available
+	fts bool
+
+	// Longest a call may spend in the database, zero for no limit
+	timeout time.Duration
+
+	// Set on the copy of the service that runs queries in a transaction
+	inTx bool
 }
 
 var (
-	// db url parameters for WAL mode, timeout for concurrent writes, and for foreing key checking
-	dburl      = os.Getenv("DB_URL") + "?_journal=WAL&_timeout=5000&_fk=true"
-	dbInstance *service
+	// A postgres:// or postgresql:// URL selects Postgres, memory:// the
+	// in-memory database and anything else is the path of a SQLite database
+	dburl      = os.Getenv("DB_URL")
+	dbDialect  = dialectFor(dburl)
+	dbInstance Service
 )
 
-func New() Service {
+// Dialect returns the name of the SQL dialect of the configured database,
+// sqlite or postgres.
+func Dialect() string {
+	return dbDialect.name
+}
+
+// Open opens the database without migrating its schema.
+func Open() (*sql.DB, error) {
+	return openDB(dburl)
+}
+
+/* Opens the database at url without migrating its schema. */
+func openDB(url string) (*sql.DB, error) {
+	if url == memoryURL {
+		return nil, errNoSQL
+	}
+
+	if dialectFor(url).name == postgresDialect.name {
+		config, err := pgx.ParseConfig(url)
+		if err != nil {
+			return nil, err
+		}
+		// Timestamps are stored without a time zone and are always UTC
+		config.RuntimeParams["timezone"] = "UTC"
+		return stdlib.OpenDB(*config), nil
+	}
+
+	// This will not be a connection error, but a DSN parse error or
+	// another initialization error.
+	// db url parameters for WAL mode, timeout for concurrent writes, and for foreing key checking
+	// Transactions take the write lock when they begin, so concurrent ones wait
+	// for the timeout instead of failing when they first write
+	return sql.Open("sqlite3", url+"?_journal=WAL&_timeout=5000&_fk=true&_txlock=immediate")
+}
+
+// New opens the database and applies any pending schema migrations.
+func New() (Service, error) {
 	// Reuse Connection
 	if dbInstance != nil {
-		return dbInstance
+		return dbInstance, nil
+	}
+
+	if dburl == memoryURL {
+		dbInstance = NewMemory()
+		return dbInstance, nil
 	}
 
-	db, err := sql.Open("sqlite3", dburl)
+	s, err := connect(dburl)
 	if err != nil {
-		// This will not be a connection error, but a DSN parse error or
-		// another initialization error.
-		log.Fatal(err)
+		return nil, err
 	}
+	dbInstance = s
+	return dbInstance, nil
+}
 
-	// Users table initialization query if it does not exist
-	const createUsersTable string = `CREATE TABLE IF NOT EXISTS users (
-		id TEXT NOT NULL PRIMARY KEY,
-		name TEXT NOT NULL,
-		email	TEXT NOT NULL,
-		avatarUrl DATE NOT NULL,
-		accessToken TEXT NOT NULL,
-		expiresAt DATE NOT NULL
-	);`
+/* Opens the SQL database at url and applies any pending schema migrations. */
+func connect(url string) (*service, error) {
+	dialect := dialectFor(url)
+	db, err := openDB(url)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrations.New(db, dialect.name)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrator.Up(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
 
-	// Execute initialization query
-	if _, err := db.Exec(createUsersTable); err != nil {
-		log.Println("Error creating User table")
-		log.Fatal(err)
+	// Postgres searches with its built-in full text search
+	fts := dialect.name == postgresDialect.name
+	if !fts {
+		if fts, err = searchIndexReady(db); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
-	// Todos table initializaiton query if it does not exist
-	const createTodosTable string = `CREATE TABLE IF NOT EXISTS todos (
-		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		title TEXT NOT NULL,
-		description	TEXT NOT NULL,
-		done INTEGER NOT NULL DEFAULT 0,
-		userId TEXT NOT NULL,
-		FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
-	);`
+	return &service{
+		pool:    db,
+		db:      conn{q: db, dialect: dialect},
+		fts:     fts,
+		timeout: queryTimeout(),
+	}, nil
+}
+
+// Time a call may spend in the database when DB_QUERY_TIMEOUT is not set
+const defaultQueryTimeout = 5 * time.Second
+
+/* Reads the query timeout from DB_QUERY_TIMEOUT, a duration such as 500ms or 2s where 0 disables the timeout. Falls back to the default when unset or invalid. */
+func queryTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT"))
+	if err != nil || timeout < 0 {
+		return defaultQueryTimeout
+	}
+	return timeout
+}
 
-	// Execute initialization query
-	if _, err := db.Exec(createTodosTable); err != nil {
-		log.Println("Error creating Todo table")
-		log.Fatal(err)
+/* Derives the context of a call from the caller's, adding the query timeout. */
+func (s *service) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout == 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, s.timeout)
+}
 
-	// Todos table initializaiton query if it does not exist
-	const createSessionsTable string = `CREATE TABLE IF NOT EXISTS sessions (
-		id TEXT NOT NULL PRIMARY KEY,
-		expiresAt DATE NOT NULL,
-		userId TEXT NOT NULL,
-		FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
-	);`
+// WithTx runs fn in a transaction, committing it when fn returns nil and
+// rolling it back otherwise. Calls fn makes on the Service it is given are
+// part of the transaction, including calls to WithTx, which join it.
+func (s *service) WithTx(ctx context.Context, fn func(Service) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	// Execute initialization query
-	if _, err := db.Exec(createSessionsTable); err != nil {
-		log.Println("Error creating Sessions table")
-		log.Fatal(err)
+	return s.withTx(ctx, func(tx *service) error {
+		return fn(tx)
+	})
+}
+
+/* Runs fn with a copy of the service whose queries run in a transaction, or with the service itself when it already is in one. */
+func (s *service) withTx(ctx context.Context, fn func(tx *service) error) error {
+	if s.inTx {
+		return fn(s)
 	}
 
-	dbInstance = &service{
-		db: db,
+	sqlTx, err := s.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
-	return dbInstance
+	// Does nothing once the transaction is committed
+	defer sqlTx.Rollback()
+
+	tx := *s
+	tx.db = conn{q: sqlTx, dialect: s.db.dialect}
+	tx.inTx = true
+	if err := fn(&tx); err != nil {
+		return err
+	}
+
+	return sqlTx.Commit()
 }
 
 // Health checks the health of the database connection by pinging the database.
 // It returns a map with keys indicating various health statistics.
-func (s *service) Health() map[string]string {
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+func (s *service) Health(ctx context.Context) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
 	stats := make(map[string]string)
 
 	// Ping the database
-	err := s.db.PingContext(ctx)
+	err := s.pool.PingContext(ctx)
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		log.Println(stats["error"])
 		return stats
 	}
 
@@ -135,7 +310,7 @@ func (s *service) Health() map[string]string {
 	stats["message"] = "It's healthy"
 
 	// Get database stats (like open connections, in use, idle, etc.)
-	dbStats := s.db.Stats()
+	dbStats := s.pool.Stats()
 	stats["open_connections"] = strconv.Itoa(dbStats.OpenConnections)
 	stats["in_use"] = strconv.Itoa(dbStats.InUse)
 	stats["idle"] = strconv.Itoa(dbStats.Idle)
@@ -169,145 +344,661 @@ func (s *service) Health() map[string]string {
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", dburl)
-	return s.db.Close()
+	log.Printf("Disconnected from %s database", dbDialect.name)
+	return s.pool.Close()
 }
 
 /* Retrieves all todos. Takes the userId (string) and returns an array of Todos ([]m.Todo) and an error. */
-func (s *service) GetAll(userId string) ([]m.Todo, error) {
-	todos := []m.Todo{}
-	rows, err := s.db.Query("SELECT id, title, description, done FROM todos WHERE userId=?", userId)
+func (s *service) GetAll(ctx context.Context, userId string) ([]m.Todo, error) {
+	todos, _, err := s.GetFiltered(ctx, userId, m.TodoFilter{})
+	return todos, err
+}
+
+/* Retrieves a single todo. Takes the Todo id (int) and userId (string) and returns the Todo (m.Todo) and an error. */
+func (s *service) Get(ctx context.Context, id int, userId string) (m.Todo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+todoColumns+" FROM todos WHERE id=? AND userId=? AND deletedAt IS NULL;", int64(id), userId)
+	if err != nil {
+		return m.Todo{}, err
+	}
+
+	todos, err := scanTodos(rows)
+	if err != nil {
+		return m.Todo{}, err
+	}
+	if len(todos) == 0 {
+		return m.Todo{}, ErrNotFound
+	}
+
+	if err := s.attachTags(ctx, todos, userId); err != nil {
+		return m.Todo{}, err
+	}
+
+	return todos[0], nil
+}
+
+/* Retrieves todos matching a filter, sorted and paginated as requested. Takes the userId (string) and a TodoFilter and returns an array of Todos ([]m.Todo), the cursor of the next page (string, empty on the last page) and an error. */
+func (s *service) GetFiltered(ctx context.Context, userId string, filter m.TodoFilter) ([]m.Todo, string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sortKey := filter.Sort
+	if sortKey == "" {
+		sortKey = m.SortPosition
+	}
+	sortExpr, ok := sortExpressions[sortKey]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: unknown sort key %q", ErrInvalidFilter, sortKey)
+	}
+
+	query := "SELECT " + todoColumns + ", " + sortExpr + " FROM todos WHERE userId=? AND deletedAt IS NULL"
+	args := []any{userId}
+
+	if filter.ListId != nil {
+		query += " AND listId=?"
+		args = append(args, *filter.ListId)
+	}
+	if filter.Done != nil {
+		query += " AND done=?"
+		args = append(args, boolInt(*filter.Done))
+	}
+	if filter.DueFrom != nil {
+		query += " AND dueAt>=?"
+		args = append(args, formatTime(filter.DueFrom))
+	}
+	if filter.DueBefore != nil {
+		query += " AND dueAt<?"
+		args = append(args, formatTime(filter.DueBefore))
+	}
+	if filter.StartFrom != nil {
+		query += " AND startAt>=?"
+		args = append(args, formatTime(filter.StartFrom))
+	}
+	if filter.StartBefore != nil {
+		query += " AND startAt<?"
+		args = append(args, formatTime(filter.StartBefore))
+	}
+	if filter.Text != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Text) + "%"
+		query += ` AND (LOWER(title) LIKE LOWER(?) ESCAPE '\' OR LOWER(description) LIKE LOWER(?) ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+	for _, tag := range filter.Tags {
+		query += " AND id IN (SELECT todoId FROM todo_tags JOIN tags ON tags.id=todo_tags.tagId WHERE tags.userId=? AND tags.name=?)"
+		args = append(args, userId, tag)
+	}
+	for _, tag := range filter.ExcludedTags {
+		query += " AND id NOT IN (SELECT todoId FROM todo_tags JOIN tags ON tags.id=todo_tags.tagId WHERE tags.userId=? AND tags.name=?)"
+		args = append(args, userId, tag)
+	}
+
+	// Keyset pagination: continue strictly after the last row of the previous
+	// page, using the id to break ties between equal sort values
+	direction, compare := "ASC", ">"
+	if filter.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil || c.Sort != sortKey || c.Desc != filter.Desc {
+			return nil, "", fmt.Errorf("%w: cursor does not match this query", ErrInvalidFilter)
+		}
+		query += " AND (" + sortExpr + compare + "? OR (" + sortExpr + "=? AND id" + compare + "?))"
+		args = append(args, c.Value, c.Value, c.ID)
+	}
+
+	query += " ORDER BY " + sortExpr + " " + direction + ", id " + direction
+	if filter.Limit > 0 {
+		// One extra row tells whether there is a next page
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+
+	rows, err := s.db.Query(ctx, query+";", args...)
 	if err != nil {
-		log.Fatal("Error selecting todos from database")
-		return []m.Todo{}, nil
+		log.Println("error selecting todos from database")
+		return nil, "", err
 	}
 	defer rows.Close()
+
+	todos := []m.Todo{}
+	var next string
+	var lastValue any
 	for rows.Next() {
-		todo := m.Todo{}
-		err := rows.Scan(&todo.ID, &todo.Title, &todo.Body, &todo.Done)
+		var sortValue any
+		todo, err := scanTodo(rows, &sortValue)
 		if err != nil {
-			log.Fatal("Error scanning todos from select")
-			return []m.Todo{}, nil
+			return nil, "", err
+		}
+		if filter.Limit > 0 && len(todos) == filter.Limit {
+			next = encodeCursor(cursor{Sort: sortKey, Desc: filter.Desc, Value: lastValue, ID: todos[len(todos)-1].ID})
+			break
 		}
 		todos = append(todos, todo)
+		lastValue = sortValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
 	}
-	return todos, nil
+
+	return todos, next, s.attachTags(ctx, todos, userId)
 }
 
-/* Marks todo as done. Takes the Todo id (int64) and returns the Todo id (int) and an error. */
-func (s *service) MarkDone(id int64) error {
-	var currentValue int
-	var isDone int
-	row := s.db.QueryRow("SELECT Done FROM todos WHERE id=?;", id)
-	row.Scan(&currentValue)
+/* Deprecated: toggles todo between done and not done depending on current status, use SetDone. Takes the Todo id (int64), userId (string), completeChildren (bool) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *service) MarkDone(ctx context.Context, id int64, userId string, completeChildren bool, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	if currentValue == 0 {
-		isDone = 1
-	} else {
-		isDone = 0
-	}
+	return s.withTx(ctx, func(tx *service) error {
+		var done bool
+		if err := tx.db.QueryRow(ctx, "SELECT done FROM todos WHERE id=? AND userId=? AND deletedAt IS NULL;", id, userId).Scan(&done); err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
 
-	// Mark Todo as done or not done depending on current status
-	_, err := s.db.Exec("UPDATE todos SET done=? WHERE id=?;", isDone, id)
-	if err != nil {
-		return err
-	}
+		return tx.SetDone(ctx, id, userId, !done, completeChildren, version)
+	})
+}
 
-	return nil
+/* Marks todo as done or not done. Setting the state it already has changes nothing, not even the version, so the call is safe to retry. completedAt holds the time the todo was completed and is cleared when it is undone. When completeChildren is set and the todo is done, all of its subtasks are completed too. Takes the Todo id (int64), userId (string), done (bool), completeChildren (bool) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *service) SetDone(ctx context.Context, id int64, userId string, done bool, completeChildren bool, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		res, err := tx.db.Exec(ctx, `UPDATE todos SET `+bumpVersion+`,
+			completedAt=CASE WHEN ?=0 THEN NULL ELSE CURRENT_TIMESTAMP END,
+			done=?
+			WHERE id=? AND userId=? AND deletedAt IS NULL AND done<>? AND (?=0 OR version=?);`, boolInt(done), boolInt(done), id, userId, boolInt(done), version, version)
+		if err != nil {
+			return err
+		}
+
+		// A todo already in the requested state is left alone, keeping its
+		// version, its subtasks and its series
+		if err := tx.checkVersion(ctx, res, int(id), userId, version); errors.Is(err, ErrNotFound) {
+			_, err := tx.position(ctx, int(id), userId)
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if !done {
+			return nil
+		}
+
+		if completeChildren {
+			if err := tx.completeDescendants(ctx, id, userId); err != nil {
+				return err
+			}
+		}
+
+		// Completing an occurrence of a recurring todo schedules the next one
+		return tx.createNextOccurrence(ctx, id, userId)
+	})
 }
 
 /* Creates new Todo. Takes a Todo struct and returns an id (int) and an error. */
-func (s *service) Create(todo m.NewTodo, userId string) (int, error) {
-	res, err := s.db.Exec("INSERT INTO todos VALUES(NULL,?,?,?,?);", todo.Title, todo.Description, 0, userId)
+func (s *service) Create(ctx context.Context, todo m.NewTodo, userId string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := s.withTx(ctx, func(tx *service) error {
+		var err error
+		id, err = tx.create(ctx, todo, userId)
+		return err
+	})
+	return id, err
+}
+
+func (s *service) create(ctx context.Context, todo m.NewTodo, userId string) (int, error) {
+	if todo.Recurrence != "" {
+		if _, err := validateRecurrence(todo.Recurrence, todo.DueAt); err != nil {
+			return -1, err
+		}
+	}
+
+	// Todos without a list go to their parent's list or the user's inbox
+	var listId int
+	var err error
+	if todo.ListId != nil {
+		listId, err = *todo.ListId, s.checkListOwner(ctx, *todo.ListId, userId)
+	} else if todo.ParentId != nil {
+		listId, err = s.todoListId(ctx, *todo.ParentId, userId)
+	} else {
+		listId, err = s.inboxId(ctx, userId)
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	// New todos are appended to the end of the user's list
+	newId, err := s.db.insert(ctx, `INSERT INTO todos (title, description, done, userId, priority, position, listId, parentId, dueAt, startAt, updatedAt)
+		VALUES(?,?,?,?,?,(SELECT COALESCE(MAX(position), 0) + 1 FROM todos WHERE userId=?),?,?,?,?,CURRENT_TIMESTAMP);`,
+		todo.Title,
+		todo.Description,
+		0,
+		userId,
+		todo.Priority,
+		userId,
+		listId,
+		todo.ParentId,
+		formatTime(todo.DueAt),
+		formatTime(todo.StartAt))
 
 	if err != nil {
 		log.Println("error trying to insert new todo into database")
 		return -1, err
 	}
 
-	var id int64
+	id := int64(newId)
 
-	if id, err = res.LastInsertId(); err != nil {
-		log.Println("error retreiving last inserted id")
+	if err := s.setTodoTags(ctx, id, todo.Tags, userId); err != nil {
+		log.Println("error attaching tags to new todo")
 		return -1, err
 	}
 
+	if todo.Recurrence != "" {
+		if err := s.setRecurrence(ctx, id, todo.Recurrence, todo.DueAt, userId); err != nil {
+			log.Println("error creating series for new todo")
+			return -1, err
+		}
+	}
+
 	return int(id), nil
 }
 
-/* Edit Todo. Takes an EditedTodo struct and returnds an id (int) and an error. */
-func (s *service) Edit(id int, newData m.NewTodo, userId string) error {
-	_, err := s.db.Exec("UPDATE todos SET title=?, description=? WHERE id=? AND userId=?;", newData.Title, newData.Description, int64(id), userId)
-	if err != nil {
-		return err
+/* Edit Todo, replacing its title, body, priority and dates. The list, tags and recurrence are kept when newData leaves them out. Takes the Todo id (int), a NewTodo struct, userId (string) and the version (int) the edit was based on, or 0 to overwrite any version, and returns an error. */
+func (s *service) Edit(ctx context.Context, id int, newData m.NewTodo, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		if newData.Recurrence != "" {
+			if _, err := validateRecurrence(newData.Recurrence, newData.DueAt); err != nil {
+				return err
+			}
+		}
+
+		// The list is only changed when the edit includes one
+		if newData.ListId != nil {
+			if err := tx.checkListOwner(ctx, *newData.ListId, userId); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+", title=?, description=?, priority=?, listId=COALESCE(?, listId), dueAt=?, startAt=? WHERE id=? AND userId=? AND deletedAt IS NULL AND (?=0 OR version=?);",
+			newData.Title,
+			newData.Description,
+			newData.Priority,
+			newData.ListId,
+			formatTime(newData.DueAt),
+			formatTime(newData.StartAt),
+			int64(id),
+			userId,
+			version,
+			version)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.checkVersion(ctx, res, id, userId, version); err != nil {
+			return err
+		}
+
+		// Tags are only replaced when the edit includes them
+		if newData.Tags != nil {
+			if err := tx.setTodoTags(ctx, int64(id), newData.Tags, userId); err != nil {
+				return err
+			}
+		}
+
+		// The recurrence rule is only replaced when the edit includes one
+		if newData.Recurrence != "" {
+			return tx.setRecurrence(ctx, int64(id), newData.Recurrence, newData.DueAt, userId)
+		}
+
+		return nil
+	})
+}
+
+/* Moves todo between two neighbours by giving it a position halfway between theirs, so no other rows need to be renumbered. Takes the Todo id (int), the neighbours (m.MoveTodo), userId (string) and the version (int) the move was based on, or 0 for any version, and returns an error. */
+func (s *service) Move(ctx context.Context, id int, move m.MoveTodo, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		low, high, err := tx.neighbourPositions(ctx, id, move, userId)
+		if err != nil {
+			return err
+		}
+
+		// Repeated moves into the same gap eventually exhaust float precision,
+		// so renumber the user's todos once and look the neighbours up again
+		if high-low < minPositionGap {
+			if err := tx.renumberPositions(ctx, userId); err != nil {
+				return err
+			}
+			if low, high, err = tx.neighbourPositions(ctx, id, move, userId); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+", position=? WHERE id=? AND userId=? AND deletedAt IS NULL AND (?=0 OR version=?);", low+(high-low)/2, int64(id), userId, version, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.checkVersion(ctx, res, id, userId, version)
+	})
+}
+
+// Smallest gap between two neighbours before positions are renumbered
+const minPositionGap = 1e-9
+
+// ErrInvalidMove is returned when the neighbours of a move are not in order or include the moved todo.
+var ErrInvalidMove = errors.New("invalid move: neighbours must be distinct and in order")
+
+/* Returns the positions a moved todo must fall between. When only one neighbour is given, the other is the todo next to it in the list, or one step past it at either end. */
+func (s *service) neighbourPositions(ctx context.Context, id int, move m.MoveTodo, userId string) (float64, float64, error) {
+	var low, high float64
+
+	if move.After == nil && move.Before == nil {
+		return 0, 0, ErrInvalidMove
+	}
+	if (move.After != nil && *move.After == id) || (move.Before != nil && *move.Before == id) {
+		return 0, 0, ErrInvalidMove
 	}
 
-	return nil
+	var err error
+	if move.After != nil {
+		if low, err = s.position(ctx, *move.After, userId); err != nil {
+			return 0, 0, err
+		}
+	}
+	if move.Before != nil {
+		if high, err = s.position(ctx, *move.Before, userId); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if move.Before == nil {
+		if err := s.db.QueryRow(ctx, "SELECT COALESCE(MIN(position), ?) FROM todos WHERE userId=? AND deletedAt IS NULL AND position>? AND id<>?;",
+			low+1, userId, low, int64(id)).Scan(&high); err != nil {
+			return 0, 0, err
+		}
+	}
+	if move.After == nil {
+		if err := s.db.QueryRow(ctx, "SELECT COALESCE(MAX(position), ?) FROM todos WHERE userId=? AND deletedAt IS NULL AND position<? AND id<>?;",
+			high-1, userId, high, int64(id)).Scan(&low); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if low >= high {
+		return 0, 0, ErrInvalidMove
+	}
+
+	return low, high, nil
 }
 
-/* Save user to database upon successful login and creates new session. */
-func (s *service) SaveUser(user goth.User, sessionId string) (string, error) {
+/* Retrieves the position of one of the user's todos, returning ErrNotFound if it is missing or owned by someone else. */
+func (s *service) position(ctx context.Context, id int, userId string) (float64, error) {
+	var position float64
+	if err := s.db.QueryRow(ctx, "SELECT position FROM todos WHERE id=? AND userId=? AND deletedAt IS NULL;", int64(id), userId).Scan(&position); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return position, nil
+}
+
+/* Resets the positions of a user's todos to consecutive integers, keeping their current order. */
+func (s *service) renumberPositions(ctx context.Context, userId string) error {
+	_, err := s.db.Exec(ctx, `UPDATE todos SET position=(
+		SELECT COUNT(*) FROM todos AS t
+		WHERE t.userId=todos.userId AND (t.position < todos.position OR (t.position = todos.position AND t.id <= todos.id))
+	) WHERE userId=?;`, userId)
+	return err
+}
+
+/* Saves user to database upon successful login, creating the user and their inbox list on first login. */
+func (s *service) SaveUser(ctx context.Context, user goth.User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		return tx.saveUser(ctx, user)
+	})
+}
+
+func (s *service) saveUser(ctx context.Context, user goth.User) error {
 	var userId string
 
-	// If userId does not exists, insert user and session in database
-	if err := s.db.QueryRow("SELECT id FROM users WHERE id = ?;",
-		user.UserID).Scan(&userId); err != nil {
-		if err == sql.ErrNoRows {
-			_, err := s.db.Exec("INSERT INTO users VALUES(?,?,?,?,?,?);",
-				user.UserID,
-				user.Name,
-				user.Email,
-				user.AvatarURL,
-				user.AccessToken,
-				user.ExpiresAt)
-
-			if err != nil {
-				log.Println("could not insert new user to database")
-				return string(0), err
-			}
+	// If userId does not exists, insert user in database
+	err := s.db.QueryRow(ctx, "SELECT id FROM users WHERE id = ?;", user.UserID).Scan(&userId)
+	if err == sql.ErrNoRows {
+		_, err := s.db.Exec(ctx, "INSERT INTO users (id, name, email, avatarUrl, accessToken, expiresAt) VALUES(?,?,?,?,?,?);",
+			user.UserID,
+			user.Name,
+			user.Email,
+			user.AvatarURL,
+			user.AccessToken,
+			user.ExpiresAt)
 
-			_, err = s.db.Exec("INSERT INTO sessions VALUES(?,date('now','+14 day'),?);",
-				sessionId,
-				user.UserID)
+		if err != nil {
+			log.Println("could not insert new user to database")
+			return err
+		}
 
-			if err != nil {
-				log.Println("could not insert session to database")
-				return string(0), err
-			}
+		if _, err := s.inboxId(ctx, user.UserID); err != nil {
+			log.Println("could not create inbox list for new user")
+			return err
+		}
 
-			return sessionId, nil
+		return nil
+	}
+
+	return err
+}
+
+/* Moves todo to the trash together with its subtasks, so purging the todo cannot take live subtasks with it. Takes the Todo id (int), userId (string) and the version (int) the delete was based on, or 0 for any version, and returns an error. */
+func (s *service) Delete(ctx context.Context, id int, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		res, err := tx.trash(ctx, "id=? AND (?=0 OR version=?)", int64(id), version, version, userId)
+		if err != nil {
+			return err
 		}
+
+		return tx.checkVersion(ctx, res, id, userId, version)
+	})
+}
+
+/* Moves the user's todos matching a condition to the trash together with their subtasks. The condition comes first among the arguments, followed by the userId. */
+func (s *service) trash(ctx context.Context, condition string, args ...any) (sql.Result, error) {
+	return s.db.Exec(ctx, `WITH RECURSIVE subtree(id) AS (
+		SELECT id FROM todos WHERE `+condition+` AND userId=? AND deletedAt IS NULL
+		UNION
+		SELECT todos.id FROM todos JOIN subtree ON todos.parentId=subtree.id WHERE todos.deletedAt IS NULL
+	) UPDATE todos SET `+bumpVersion+`, deletedAt=CURRENT_TIMESTAMP WHERE id IN (SELECT id FROM subtree);`, args...)
+}
+
+/* Retrieves trashed todos. Takes the userId (string) and returns an array of Todos ([]m.Todo) and an error. */
+func (s *service) GetTrash(ctx context.Context, userId string) ([]m.Todo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+todoColumns+" FROM todos WHERE userId=? AND deletedAt IS NOT NULL ORDER BY deletedAt DESC, id DESC;", userId)
+	if err != nil {
+		log.Println("error selecting trashed todos from database")
+		return nil, err
 	}
 
-	// If userId exists, expire previous active session if they exist and insert new session for user upon re-login
-	_, err := s.db.Exec("UPDATE sessions SET expiresAt=date('now','-1 day') WHERE expiresAt>=date('now') AND userId=?;", user.UserID)
+	todos, err := scanTodos(rows)
 	if err != nil {
-		log.Println("an error ocurred when trying to expire previous active sessions")
-		return string(0), err
+		return nil, err
 	}
 
-	_, err = s.db.Exec("INSERT INTO sessions VALUES(?,date('now','+14 day'),?);",
-		sessionId,
-		user.UserID)
+	return todos, s.attachTags(ctx, todos, userId)
+}
+
+/* Restores todo from the trash together with its trashed subtasks. A todo whose parent is still in the trash becomes a top level todo. Takes the Todo id (int) and userId (string) and returns an error. */
+func (s *service) Restore(ctx context.Context, id int, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		_, err := tx.db.Exec(ctx, `UPDATE todos SET parentId=NULL
+			WHERE id=? AND userId=? AND parentId IN (SELECT id FROM todos WHERE deletedAt IS NOT NULL);`, int64(id), userId)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.db.Exec(ctx, `WITH RECURSIVE subtree(id) AS (
+			SELECT id FROM todos WHERE id=? AND userId=? AND deletedAt IS NOT NULL
+			UNION
+			SELECT todos.id FROM todos JOIN subtree ON todos.parentId=subtree.id WHERE todos.deletedAt IS NOT NULL
+		) UPDATE todos SET `+bumpVersion+`, deletedAt=NULL WHERE id IN (SELECT id FROM subtree);`, int64(id), userId)
+		if err != nil {
+			return err
+		}
+
+		return checkAffected(res)
+	})
+}
+
+/* Permanently removes todos that have been in the trash longer than the retention period in days. Returns the number of purged todos and an error. */
+func (s *service) PurgeTrash(ctx context.Context, retentionDays int) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	res, err := s.db.Exec(ctx, "DELETE FROM todos WHERE deletedAt IS NOT NULL AND deletedAt < ?;", formatTime(&cutoff))
 	if err != nil {
-		log.Println("an error ocurred when trying to insert new session to database")
-		return string(0), err
+		return 0, err
 	}
 
-	return sessionId, nil
+	return res.RowsAffected()
 }
 
-/* Validates session. Takes sessionId (string) and returns userId (string) if valid and an error */
-func (s *service) IsSessionIdValid(sessionId string) (string, error) {
-	var userId string
-	if err := s.db.QueryRow("SELECT userId FROM sessions WHERE id = ? AND expiresAt > date('now');",
-		sessionId).Scan(&userId); err != nil {
+/* Retrieves the IANA time zone name of a user. Takes the userId (string) and returns the time zone (string) and an error. */
+func (s *service) GetTimeZone(ctx context.Context, userId string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var timeZone string
+	if err := s.db.QueryRow(ctx, "SELECT timeZone FROM users WHERE id=?;", userId).Scan(&timeZone); err != nil {
+		return "", err
+	}
+
+	return timeZone, nil
+}
+
+/* Sets the IANA time zone name of a user. Takes the userId (string) and time zone (string) and returns an error. */
+func (s *service) SetTimeZone(ctx context.Context, userId string, timeZone string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx, "UPDATE users SET timeZone=? WHERE id=?;", timeZone, userId)
+	return err
+}
+
+// Assignments added to every update of a todo's fields so clients can detect
+// concurrent changes. Renumbering positions does not count as a change.
+const bumpVersion = "version=version+1, updatedAt=CURRENT_TIMESTAMP"
+
+// ErrVersionConflict is returned when a todo was changed after the version an edit was based on.
+var ErrVersionConflict = errors.New("todo was changed by another request")
+
+/* Returns ErrNotFound when a statement scoped by id and userId matched no rows. */
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+/* Returns the error of a write to a todo that was based on a version, or on any version when it is 0, and matched no rows: ErrVersionConflict when the todo exists with another version, ErrNotFound otherwise. */
+func (s *service) checkVersion(ctx context.Context, res sql.Result, id int, userId string, version int) error {
+	err := checkAffected(res)
+	if !errors.Is(err, ErrNotFound) || version == 0 {
+		return err
+	}
+
+	// The todo exists but was changed since the client read it
+	var current int
+	if err := s.db.QueryRow(ctx, "SELECT version FROM todos WHERE id=? AND userId=? AND deletedAt IS NULL;", int64(id), userId).Scan(&current); err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("no valid session exists in the database, please login")
-			return string(0), err
+			return ErrNotFound
 		}
+		return err
+	}
+	if current != version {
+		return ErrVersionConflict
+	}
+
+	return ErrNotFound
+}
+
+// Columns selected for a todo, in the order expected by scanTodos
+const todoColumns = `id, title, description, done, priority, position, listId, parentId, seriesId, dueAt, startAt, completedAt, deletedAt, version, updatedAt,
+	COALESCE((SELECT rule FROM series WHERE series.id=todos.seriesId AND series.ended=0), ''),
+	(SELECT COUNT(*) FROM todos AS c WHERE c.parentId=todos.id AND c.deletedAt IS NULL),
+	(SELECT COUNT(*) FROM todos AS c WHERE c.parentId=todos.id AND c.deletedAt IS NULL AND c.done=1)`
+
+/* Scans rows selected with todoColumns into Todos and closes the rows. */
+func scanTodos(rows *sql.Rows) ([]m.Todo, error) {
+	defer rows.Close()
+
+	todos := []m.Todo{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	return todos, rows.Err()
+}
+
+/* Scans the current row selected with todoColumns into a Todo. Columns selected after todoColumns are scanned into extra. */
+func scanTodo(rows *sql.Rows, extra ...any) (m.Todo, error) {
+	todo := m.Todo{}
+	dest := []any{&todo.ID, &todo.Title, &todo.Body, &todo.Done, &todo.Priority, &todo.Position, &todo.ListId, &todo.ParentId, &todo.SeriesId, &todo.DueAt, &todo.StartAt, &todo.CompletedAt, &todo.DeletedAt, &todo.Version, &todo.UpdatedAt, &todo.Recurrence, &todo.ChildrenTotal, &todo.ChildrenDone}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		log.Println("error scanning todos from select")
+		return todo, err
+	}
+
+	return todo, nil
+}
+
+// Layout used to store timestamps, matching SQLite's CURRENT_TIMESTAMP so
+// stored values compare correctly against it and each other
+const timeLayout = "2006-01-02 15:04:05"
+
+/* Formats an optional time as a UTC timestamp for storage, or nil when unset. */
+func formatTime(t *time.Time) any {
+	if t == nil {
+		return nil
 	}
 
-	return userId, nil
+	return t.UTC().Format(timeLayout)
 }
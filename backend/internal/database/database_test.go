@@ -0,0 +1,504 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/raziel-aleman/go-todo-app/internal/migrations"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Postgres database the shared tests run against as well when set. Its
+// schema is dropped and migrated again before every test.
+var testPostgresURL = os.Getenv("TEST_POSTGRES_URL")
+
+/* Runs a test against every backend, each with an empty database. */
+func forEachBackend(t *testing.T, test func(t *testing.T, db Service)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, openSQL(t, filepath.Join(t.TempDir(), "test.db")))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		if testPostgresURL == "" {
+			t.Skip("TEST_POSTGRES_URL is not set")
+		}
+		resetPostgres(t)
+		test(t, openSQL(t, testPostgresURL))
+	})
+}
+
+/* Opens and migrates the SQL database at url, closing it when the test ends. */
+func openSQL(t *testing.T, url string) Service {
+	t.Helper()
+
+	s, err := connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+/* Reverts every migration of the test Postgres database. */
+func resetPostgres(t *testing.T) {
+	t.Helper()
+
+	db, err := openDB(testPostgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, postgresDialect.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.To(0); err != nil {
+		t.Fatalf("resetting postgres: %v", err)
+	}
+}
+
+/* Saves users with the given ids. */
+func saveUsers(t *testing.T, db Service, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		if err := db.SaveUser(context.Background(), goth.User{UserID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+/* Creates a todo and returns its id. */
+func createTodo(t *testing.T, db Service, todo m.NewTodo, userId string) int {
+	t.Helper()
+
+	id, err := db.Create(context.Background(), todo, userId)
+	if err != nil {
+		t.Fatalf("creating %q: %v", todo.Title, err)
+	}
+	return id
+}
+
+/* Returns a todo, failing the test when it cannot be read. */
+func getTodo(t *testing.T, db Service, id int, userId string) m.Todo {
+	t.Helper()
+
+	todo, err := db.Get(context.Background(), id, userId)
+	if err != nil {
+		t.Fatalf("getting todo %d: %v", id, err)
+	}
+	return todo
+}
+
+/* Returns the ids of todos in order. */
+func todoIds(todos []m.Todo) []int {
+	ids := []int{}
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	return ids
+}
+
+func TestCreateAndGet(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1", "u2")
+
+		due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+		id := createTodo(t, db, m.NewTodo{Title: "Buy milk", Description: "at the store", Priority: m.PriorityHigh, DueAt: &due}, "u1")
+
+		todo := getTodo(t, db, id, "u1")
+		if todo.Title != "Buy milk" || todo.Body != "at the store" || todo.Priority != m.PriorityHigh || todo.Done || todo.Version != 1 {
+			t.Errorf("created todo = %+v", todo)
+		}
+		if todo.DueAt == nil || !todo.DueAt.Equal(due) {
+			t.Errorf("due at %v, want %v", todo.DueAt, due)
+		}
+		if todo.ListId == nil {
+			t.Error("new todo is not in the inbox")
+		}
+
+		// Todos of other users are not found rather than forbidden
+		if _, err := db.Get(ctx, id, "u2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("getting another user's todo: %v, want ErrNotFound", err)
+		}
+		if err := db.Edit(ctx, id, m.NewTodo{Title: "mine"}, "u2", 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("editing another user's todo: %v, want ErrNotFound", err)
+		}
+		if err := db.Delete(ctx, id, "u2", 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleting another user's todo: %v, want ErrNotFound", err)
+		}
+		if todos, err := db.GetAll(ctx, "u2"); err != nil || len(todos) != 0 {
+			t.Errorf("todos of u2 = %v, %v", todos, err)
+		}
+	})
+}
+
+func TestEditVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		id := createTodo(t, db, m.NewTodo{Title: "first"}, "u1")
+
+		if err := db.Edit(ctx, id, m.NewTodo{Title: "second"}, "u1", 1); err != nil {
+			t.Fatalf("editing version 1: %v", err)
+		}
+		if todo := getTodo(t, db, id, "u1"); todo.Title != "second" || todo.Version != 2 {
+			t.Fatalf("edited todo = %+v", todo)
+		}
+
+		if err := db.Edit(ctx, id, m.NewTodo{Title: "stale"}, "u1", 1); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("editing a stale version: %v, want ErrVersionConflict", err)
+		}
+		if err := db.Delete(ctx, id, "u1", 1); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("deleting a stale version: %v, want ErrVersionConflict", err)
+		}
+
+		// Version 0 edits whatever version is current
+		if err := db.Edit(ctx, id, m.NewTodo{Title: "third"}, "u1", 0); err != nil {
+			t.Fatalf("editing any version: %v", err)
+		}
+		if todo := getTodo(t, db, id, "u1"); todo.Title != "third" || todo.Version != 3 {
+			t.Fatalf("edited todo = %+v", todo)
+		}
+	})
+}
+
+func TestSetDoneIsIdempotent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		id := createTodo(t, db, m.NewTodo{Title: "todo"}, "u1")
+
+		for i := 0; i < 2; i++ {
+			if err := db.SetDone(ctx, int64(id), "u1", true, false, 0); err != nil {
+				t.Fatalf("setting done: %v", err)
+			}
+		}
+		todo := getTodo(t, db, id, "u1")
+		if !todo.Done || todo.CompletedAt == nil || todo.Version != 2 {
+			t.Fatalf("todo done twice = %+v", todo)
+		}
+
+		if err := db.SetDone(ctx, int64(id), "u1", false, false, 1); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("undoing a stale version: %v, want ErrVersionConflict", err)
+		}
+	})
+}
+
+func TestRetriedCompletionChangesNothing(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+		id := createTodo(t, db, m.NewTodo{Title: "Buy milk", Recurrence: "FREQ=DAILY", DueAt: &due}, "u1")
+		child := createTodo(t, db, m.NewTodo{Title: "oat milk", ParentId: &id}, "u1")
+
+		if err := db.SetDone(ctx, int64(id), "u1", true, false, 0); err != nil {
+			t.Fatal(err)
+		}
+		todos, err := db.GetAll(ctx, "u1")
+		if err != nil || len(todos) != 3 {
+			t.Fatalf("todos after completing the occurrence = %v, %v", todoIds(todos), err)
+		}
+		next := todos[2].ID
+		if err := db.Delete(ctx, next, "u1", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// The retry neither schedules another occurrence nor completes subtasks
+		if err := db.SetDone(ctx, int64(id), "u1", true, true, 0); err != nil {
+			t.Fatal(err)
+		}
+		if todos, err := db.GetAll(ctx, "u1"); err != nil || !slices.Equal(todoIds(todos), []int{id, child}) {
+			t.Fatalf("todos after the retry = %v, %v", todoIds(todos), err)
+		}
+		if todo := getTodo(t, db, child, "u1"); todo.Done {
+			t.Errorf("retry completed the subtask: %+v", todo)
+		}
+
+		// Undoing clears the completion time
+		if err := db.SetDone(ctx, int64(id), "u1", false, false, 0); err != nil {
+			t.Fatal(err)
+		}
+		if todo := getTodo(t, db, id, "u1"); todo.Done || todo.CompletedAt != nil {
+			t.Errorf("undone todo = %+v", todo)
+		}
+	})
+}
+
+func TestSubtasks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		parent := createTodo(t, db, m.NewTodo{Title: "parent"}, "u1")
+		child := createTodo(t, db, m.NewTodo{Title: "child", ParentId: &parent}, "u1")
+		grandchild := createTodo(t, db, m.NewTodo{Title: "grandchild", ParentId: &child}, "u1")
+
+		if err := db.SetParent(ctx, parent, &grandchild, "u1", 0); !errors.Is(err, ErrCycle) {
+			t.Fatalf("moving a todo under its subtask: %v, want ErrCycle", err)
+		}
+
+		if err := db.SetDone(ctx, int64(parent), "u1", true, true, 0); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []int{child, grandchild} {
+			if todo := getTodo(t, db, id, "u1"); !todo.Done {
+				t.Errorf("subtask %d was not completed with its parent", id)
+			}
+		}
+
+		children, err := db.GetChildren(ctx, parent, "u1")
+		if err != nil || !slices.Equal(todoIds(children), []int{child}) {
+			t.Fatalf("children = %v, %v", todoIds(children), err)
+		}
+		if todo := getTodo(t, db, parent, "u1"); todo.ChildrenDone != 1 || todo.ChildrenTotal != 1 {
+			t.Errorf("parent counts %d of %d subtasks done", todo.ChildrenDone, todo.ChildrenTotal)
+		}
+	})
+}
+
+func TestDeleteAndRestoreSubtree(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		parent := createTodo(t, db, m.NewTodo{Title: "parent"}, "u1")
+		child := createTodo(t, db, m.NewTodo{Title: "child", ParentId: &parent}, "u1")
+		grandchild := createTodo(t, db, m.NewTodo{Title: "grandchild", ParentId: &child}, "u1")
+		other := createTodo(t, db, m.NewTodo{Title: "other"}, "u1")
+
+		if err := db.Delete(ctx, parent, "u1", 0); err != nil {
+			t.Fatal(err)
+		}
+		todos, err := db.GetAll(ctx, "u1")
+		if err != nil || !slices.Equal(todoIds(todos), []int{other}) {
+			t.Fatalf("todos after delete = %v, %v", todoIds(todos), err)
+		}
+		trash, err := db.GetTrash(ctx, "u1")
+		if err != nil || len(trash) != 3 {
+			t.Fatalf("trash = %v, %v", todoIds(trash), err)
+		}
+
+		// Trashed subtasks are not completed with their parent
+		if err := db.SetDone(ctx, int64(parent), "u1", true, true, 0); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("completing a trashed todo: %v, want ErrNotFound", err)
+		}
+
+		if err := db.Restore(ctx, parent, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		if todos, err = db.GetAll(ctx, "u1"); err != nil || len(todos) != 4 {
+			t.Fatalf("todos after restore = %v, %v", todoIds(todos), err)
+		}
+		if todo := getTodo(t, db, grandchild, "u1"); todo.ParentId == nil || *todo.ParentId != child || todo.Done {
+			t.Errorf("restored grandchild = %+v", todo)
+		}
+
+		// A subtask restored without its parent is detached from it
+		if err := db.Delete(ctx, parent, "u1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Restore(ctx, child, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		if todo := getTodo(t, db, child, "u1"); todo.ParentId != nil {
+			t.Errorf("child restored without its parent has parent %d", *todo.ParentId)
+		}
+		if todo := getTodo(t, db, grandchild, "u1"); todo.ParentId == nil || *todo.ParentId != child {
+			t.Errorf("grandchild was not restored with the child: %+v", todo)
+		}
+	})
+}
+
+func TestDeleteList(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1", "u2")
+
+		lists, err := db.GetLists(ctx, "u1", false)
+		if err != nil || len(lists) != 1 || !lists[0].Inbox {
+			t.Fatalf("lists of a new user = %+v, %v", lists, err)
+		}
+		inbox := lists[0].ID
+
+		listId, err := db.CreateList(ctx, m.NewList{Name: "work"}, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := createTodo(t, db, m.NewTodo{Title: "report", ListId: &listId}, "u1")
+
+		if err := db.DeleteList(ctx, listId, "u2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("deleting another user's list: %v, want ErrNotFound", err)
+		}
+		if err := db.DeleteList(ctx, inbox, "u1"); !errors.Is(err, ErrInboxList) {
+			t.Fatalf("deleting the inbox: %v, want ErrInboxList", err)
+		}
+
+		if err := db.DeleteList(ctx, listId, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		if lists, err := db.GetLists(ctx, "u1", true); err != nil || len(lists) != 1 {
+			t.Fatalf("lists after delete = %+v, %v", lists, err)
+		}
+
+		// The todos of the list are trashed into the inbox so they can be restored
+		trash, err := db.GetTrash(ctx, "u1")
+		if err != nil || !slices.Equal(todoIds(trash), []int{id}) {
+			t.Fatalf("trash = %v, %v", todoIds(trash), err)
+		}
+		if trash[0].ListId == nil || *trash[0].ListId != inbox {
+			t.Errorf("trashed todo is in list %v, want the inbox %d", trash[0].ListId, inbox)
+		}
+		if err := db.Restore(ctx, id, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestTags(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1", "u2")
+		tagged := createTodo(t, db, m.NewTodo{Title: "tagged", Tags: []string{"home", "errand"}}, "u1")
+		createTodo(t, db, m.NewTodo{Title: "untagged"}, "u1")
+
+		if todo := getTodo(t, db, tagged, "u1"); len(todo.Tags) != 2 || todo.Tags[0] != "errand" || todo.Tags[1] != "home" {
+			t.Fatalf("tags = %v", todo.Tags)
+		}
+
+		todos, _, err := db.GetFiltered(ctx, "u1", m.TodoFilter{Tags: []string{"home"}})
+		if err != nil || !slices.Equal(todoIds(todos), []int{tagged}) {
+			t.Fatalf("todos tagged home = %v, %v", todoIds(todos), err)
+		}
+
+		tags, err := db.GetTags(ctx, "u1")
+		if err != nil || len(tags) != 2 {
+			t.Fatalf("tags of u1 = %+v, %v", tags, err)
+		}
+		if _, err := db.CreateTag(ctx, m.NewTag{Name: "home"}, "u1"); !errors.Is(err, ErrTagExists) {
+			t.Fatalf("creating a duplicate tag: %v, want ErrTagExists", err)
+		}
+		if _, err := db.CreateTag(ctx, m.NewTag{Name: "home"}, "u2"); err != nil {
+			t.Fatalf("creating a tag another user has: %v", err)
+		}
+		if err := db.DeleteTag(ctx, tags[0].ID, "u2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("deleting another user's tag: %v, want ErrNotFound", err)
+		}
+
+		if err := db.DeleteTag(ctx, tags[0].ID, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		if todo := getTodo(t, db, tagged, "u1"); len(todo.Tags) != 1 || todo.Tags[0] != "home" {
+			t.Fatalf("tags after deleting errand = %v", todo.Tags)
+		}
+	})
+}
+
+func TestSessions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1", "u2")
+		expires := time.Now().Add(time.Hour).UTC()
+
+		ids := []int{}
+		for _, hash := range []string{"a", "b", "c"} {
+			id, err := db.CreateSession(ctx, m.Session{TokenHash: hash, UserId: "u1", ExpiresAt: expires}, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+
+		// Only the newest sessions are kept
+		if _, err := db.GetSession(ctx, "a"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("getting an ended session: %v, want ErrNotFound", err)
+		}
+		session, err := db.GetSession(ctx, "c")
+		if err != nil || session.ID != ids[2] || session.UserId != "u1" {
+			t.Fatalf("session = %+v, %v", session, err)
+		}
+
+		if _, err := db.CreateSession(ctx, m.Session{TokenHash: "expired", UserId: "u1", ExpiresAt: time.Now().Add(-time.Hour).UTC()}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetSession(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("getting an expired session: %v, want ErrNotFound", err)
+		}
+
+		if err := db.DeleteSession(ctx, ids[1], "u2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("ending another user's session: %v, want ErrNotFound", err)
+		}
+		if n, err := db.DeleteOtherSessions(ctx, ids[2], "u1"); err != nil || n != 1 {
+			t.Fatalf("ended %d other sessions, %v", n, err)
+		}
+		sessions, err := db.GetSessions(ctx, "u1")
+		if err != nil || len(sessions) != 1 || sessions[0].ID != ids[2] {
+			t.Fatalf("sessions = %+v, %v", sessions, err)
+		}
+	})
+}
+
+func TestSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1", "u2")
+		milk := createTodo(t, db, m.NewTodo{Title: "Buy milk", Description: "at the store"}, "u1")
+		createTodo(t, db, m.NewTodo{Title: "Walk the dog"}, "u1")
+		createTodo(t, db, m.NewTodo{Title: "Buy milk"}, "u2")
+		trashed := createTodo(t, db, m.NewTodo{Title: "Spilt milk"}, "u1")
+		if err := db.Delete(ctx, trashed, "u1", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := db.Search(ctx, "u1", "milk", 10)
+		if err != nil || len(results) != 1 || results[0].ID != milk {
+			t.Fatalf("results = %+v, %v", results, err)
+		}
+
+		// The index follows edits
+		if err := db.Edit(ctx, milk, m.NewTodo{Title: "Buy bread"}, "u1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if results, err := db.Search(ctx, "u1", "milk", 10); err != nil || len(results) != 0 {
+			t.Fatalf("results after edit = %+v, %v", results, err)
+		}
+	})
+}
+
+func TestSearchHighlightsAreEscaped(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		createTodo(t, db, m.NewTodo{Title: `<script>alert("milk")</script> milk`, Description: `<img src=x onerror=alert(1)> milk & honey`}, "u1")
+
+		results, err := db.Search(ctx, "u1", "milk", 10)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("results = %+v, %v", results, err)
+		}
+		highlights := results[0].Highlights
+		for _, text := range []string{highlights.Title, highlights.Body} {
+			if strings.Contains(strings.NewReplacer("<mark>", "", "</mark>", "").Replace(text), "<") {
+				t.Errorf("highlight %q contains markup of the todo", text)
+			}
+		}
+		if !strings.Contains(highlights.Title, "&lt;script&gt;") || !strings.Contains(highlights.Body, "&amp; honey") {
+			t.Errorf("highlights %+v are not the escaped text", highlights)
+		}
+		// Only full text search marks the matched terms
+		if strings.Contains(highlights.Title, "<mark>") && !strings.Contains(highlights.Title, "<mark>milk</mark>") {
+			t.Errorf("title highlight %q does not mark the match", highlights.Title)
+		}
+	})
+}
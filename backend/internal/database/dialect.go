@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dialect describes how a database differs from the SQL the queries of this
+// package are written in. Queries use ? placeholders and stick to SQL both
+// SQLite and Postgres understand; what remains is handled here.
+type dialect struct {
+	// Name of the dialect, also the directory of its migrations
+	name string
+	// Whether placeholders are numbered as $1, $2... instead of ?
+	numbered bool
+	// Whether inserts return the new id with RETURNING instead of LastInsertId
+	returning bool
+	// Reports whether an error is a violation of a unique constraint
+	isUniqueViolation func(error) bool
+}
+
+var sqliteDialect = dialect{
+	name: "sqlite",
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	},
+}
+
+var postgresDialect = dialect{
+	name:      "postgres",
+	numbered:  true,
+	returning: true,
+	isUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == "23505"
+	},
+}
+
+// dialectFor returns the dialect of a DB_URL: Postgres for postgres:// and
+// postgresql:// URLs, SQLite for anything else, which is a file path.
+func dialectFor(url string) dialect {
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return postgresDialect
+	}
+	return sqliteDialect
+}
+
+/* Rewrites the ? placeholders of a query to the dialect's style, leaving string literals alone. */
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// conn runs queries written with ? placeholders in the dialect of the
+// database it is connected to.
+type conn struct {
+	q       queryer
+	dialect dialect
+}
+
+func (c conn) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.q.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.q.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.q.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+/* Runs an INSERT into a table with an id column and returns the id of the new row. */
+func (c conn) insert(ctx context.Context, query string, args ...any) (int, error) {
+	if c.dialect.returning {
+		var id int
+		query = strings.TrimSuffix(strings.TrimSpace(query), ";") + " RETURNING id;"
+		err := c.QueryRow(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+
+	res, err := c.Exec(ctx, query, args...)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+/* Converts a bool to the integer stored in flag columns such as done. */
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/* Converts an optional bool to the integer stored in flag columns, or nil when it is not set. */
+func optionalBoolInt(b *bool) any {
+	if b == nil {
+		return nil
+	}
+	return boolInt(*b)
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// ErrInboxList is returned when trying to delete or archive a user's inbox list.
+var ErrInboxList = errors.New("the inbox list cannot be deleted or archived")
+
+// Name of the list every user starts with
+const inboxName = "Inbox"
+
+/* Retrieves the lists of a user in position order. Takes the userId (string) and whether to include archived lists (bool) and returns an array of Lists ([]m.List) and an error. */
+func (s *service) GetLists(ctx context.Context, userId string, includeArchived bool) ([]m.List, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT id, name, color, archived, inbox, position FROM lists WHERE userId=?"
+	if !includeArchived {
+		query += " AND archived=0"
+	}
+
+	rows, err := s.db.Query(ctx, query+" ORDER BY position, id;", userId)
+	if err != nil {
+		log.Println("error selecting lists from database")
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []m.List{}
+	for rows.Next() {
+		list := m.List{}
+		if err := rows.Scan(&list.ID, &list.Name, &list.Color, &list.Archived, &list.Inbox, &list.Position); err != nil {
+			log.Println("error scanning lists from select")
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	return lists, rows.Err()
+}
+
+/* Creates new List at the end of the user's lists unless a position is given. Takes a NewList struct and userId (string) and returns an id (int) and an error. */
+func (s *service) CreateList(ctx context.Context, list m.NewList, userId string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	id, err := s.db.insert(ctx, `INSERT INTO lists (name, color, archived, position, userId)
+		VALUES(?,?,?,COALESCE(?, (SELECT COALESCE(MAX(position), 0) + 1 FROM lists WHERE userId=?)),?);`,
+		list.Name,
+		list.Color,
+		boolInt(list.Archived != nil && *list.Archived),
+		list.Position,
+		userId,
+		userId)
+	if err != nil {
+		log.Println("error trying to insert new list into database")
+		return -1, err
+	}
+
+	return id, nil
+}
+
+/* Edits a List. Takes the List id (int), a NewList struct and userId (string) and returns an error. */
+func (s *service) EditList(ctx context.Context, id int, list m.NewList, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		if list.Archived != nil && *list.Archived {
+			if err := tx.checkNotInbox(ctx, id, userId); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE lists SET name=?, color=?, archived=COALESCE(?, archived), position=COALESCE(?, position) WHERE id=? AND userId=?;",
+			list.Name,
+			list.Color,
+			optionalBoolInt(list.Archived),
+			list.Position,
+			int64(id),
+			userId)
+		if err != nil {
+			return err
+		}
+
+		return checkAffected(res)
+	})
+}
+
+/* Deletes a List. Its todos go to the trash, and to the inbox so they have a list to be restored to. The inbox cannot be deleted. Takes the List id (int) and userId (string) and returns an error. */
+func (s *service) DeleteList(ctx context.Context, id int, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		if err := tx.checkNotInbox(ctx, id, userId); err != nil {
+			return err
+		}
+
+		if _, err := tx.trash(ctx, "listId=?", int64(id), userId); err != nil {
+			return err
+		}
+
+		inboxId, err := tx.inboxId(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.db.Exec(ctx, "UPDATE todos SET listId=? WHERE listId=? AND userId=?;", int64(inboxId), int64(id), userId); err != nil {
+			return err
+		}
+
+		res, err := tx.db.Exec(ctx, "DELETE FROM lists WHERE id=? AND userId=?;", int64(id), userId)
+		if err != nil {
+			return err
+		}
+
+		return checkAffected(res)
+	})
+}
+
+/* Moves a todo to another of the user's lists. Takes the Todo id (int), List id (int), userId (string) and the version (int) the move was based on, or 0 for any version, and returns an error. */
+func (s *service) MoveToList(ctx context.Context, id int, listId int, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		if err := tx.checkListOwner(ctx, listId, userId); err != nil {
+			return err
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+", listId=? WHERE id=? AND userId=? AND deletedAt IS NULL AND (?=0 OR version=?);", int64(listId), int64(id), userId, version, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.checkVersion(ctx, res, id, userId, version)
+	})
+}
+
+/* Returns the id of the user's inbox list, creating it when the user does not have one yet. */
+func (s *service) inboxId(ctx context.Context, userId string) (int, error) {
+	var id int
+	err := s.db.QueryRow(ctx, "SELECT id FROM lists WHERE userId=? AND inbox=1;", userId).Scan(&id)
+	if err == nil {
+		return id, nil
+	} else if err != sql.ErrNoRows {
+		return -1, err
+	}
+
+	return s.db.insert(ctx, "INSERT INTO lists (name, inbox, position, userId) VALUES(?,1,0,?);", inboxName, userId)
+}
+
+/* Returns ErrNotFound unless the list exists and belongs to the user. */
+func (s *service) checkListOwner(ctx context.Context, listId int, userId string) error {
+	var id int
+	if err := s.db.QueryRow(ctx, "SELECT id FROM lists WHERE id=? AND userId=?;", int64(listId), userId).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+/* Returns ErrInboxList if the list is the user's inbox. */
+func (s *service) checkNotInbox(ctx context.Context, listId int, userId string) error {
+	var inbox bool
+	if err := s.db.QueryRow(ctx, "SELECT inbox FROM lists WHERE id=? AND userId=?;", int64(listId), userId).Scan(&inbox); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if inbox {
+		return ErrInboxList
+	}
+
+	return nil
+}
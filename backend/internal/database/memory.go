@@ -0,0 +1,1678 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markbates/goth"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// DB_URL selecting the in-memory database
+const memoryURL = "memory://"
+
+var (
+	// errNoSQL is returned when opening the in-memory database as a SQL database.
+	errNoSQL = errors.New("the in-memory database is not a SQL database")
+	// errForeignKey is returned when a record refers to one that does not
+	// exist, where SQL databases fail a foreign key constraint.
+	errForeignKey = errors.New("foreign key constraint failed")
+	// errSessionExists is returned when creating a session with a token that
+	// is already taken, where SQL databases fail the unique constraint.
+	errSessionExists = errors.New("session already exists")
+)
+
+// memoryService is a Service that keeps everything in memory, for tests and
+// demos. It follows the SQL implementation: records are only visible to the
+// user owning them, trashed todos are hidden, sessions expire, results come
+// back in the same order and timestamps are kept to the second in UTC.
+// Search matches substrings, as SQLite does without FTS5.
+//
+// Every call holds the lock for everything it reads and changes. Calls that
+// can fail after changing something restore the tables when they do, so that
+// like SQL statements each call is applied entirely or not at all.
+type memoryService struct {
+	*memoryStore
+
+	// Set on the service given to the function run by WithTx, which holds
+	// the lock for the whole transaction
+	inTx bool
+}
+
+// memoryStore guards the tables of an in-memory database with a lock.
+type memoryStore struct {
+	mu sync.Mutex
+	memoryTables
+}
+
+// memoryTables holds the records of an in-memory database.
+type memoryTables struct {
+	users    map[string]*memoryUser
+	sessions map[int]*m.Session
+	lists    map[int]*memoryList
+	series   map[int]*memorySeries
+	todos    map[int]*memoryTodo
+	tags     map[int]*memoryTag
+	// Tag ids attached to each todo
+	todoTags map[int]map[int]bool
+
+	// Last id handed out per table. Like AUTOINCREMENT, ids are never reused.
+	lastIds map[string]int
+}
+
+type memoryUser struct {
+	user     goth.User
+	timeZone string
+}
+
+type memoryList struct {
+	m.List
+	userId string
+}
+
+type memorySeries struct {
+	rule    string
+	startAt time.Time
+	ended   bool
+	userId  string
+}
+
+type memoryTag struct {
+	m.Tag
+	userId string
+}
+
+type memoryTodo struct {
+	id          int
+	title       string
+	description string
+	done        bool
+	userId      string
+	priority    m.Priority
+	position    float64
+	listId      *int
+	parentId    *int
+	seriesId    *int
+	dueAt       *time.Time
+	startAt     *time.Time
+	completedAt *time.Time
+	deletedAt   *time.Time
+	version     int
+	updatedAt   *time.Time
+}
+
+// NewMemory returns an empty database kept in memory, for tests and demos.
+// Setting DB_URL to memory:// makes New return one.
+func NewMemory() Service {
+	return &memoryService{memoryStore: &memoryStore{memoryTables: memoryTables{
+		users:    map[string]*memoryUser{},
+		sessions: map[int]*m.Session{},
+		lists:    map[int]*memoryList{},
+		series:   map[int]*memorySeries{},
+		todos:    map[int]*memoryTodo{},
+		tags:     map[int]*memoryTag{},
+		todoTags: map[int]map[int]bool{},
+		lastIds:  map[string]int{},
+	}}}
+}
+
+/* Reports the database as up, with the number of stored todos. */
+func (s *memoryService) Health(ctx context.Context) map[string]string {
+	if err := s.lock(ctx); err != nil {
+		return map[string]string{"status": "down", "error": err.Error()}
+	}
+	defer s.unlock()
+
+	return map[string]string{
+		"status":  "up",
+		"message": "It's healthy",
+		"todos":   strconv.Itoa(len(s.todos)),
+	}
+}
+
+// WithTx runs fn while holding the lock on the whole database, so other calls
+// wait for it, and restores every table as it was when fn returns an error.
+// Calls fn makes on the Service it is given, including WithTx, are part of
+// the transaction.
+func (s *memoryService) WithTx(ctx context.Context, fn func(Service) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	saved := s.memoryTables.clone()
+	if err := fn(&memoryService{memoryStore: s.memoryStore, inTx: true}); err != nil {
+		s.memoryTables = saved
+		return err
+	}
+
+	return nil
+}
+
+/* Does nothing, the data lives as long as the process. */
+func (s *memoryService) Close() error {
+	return nil
+}
+
+/* Retrieves all todos. Takes the userId (string) and returns an array of Todos ([]m.Todo) and an error. */
+func (s *memoryService) GetAll(ctx context.Context, userId string) ([]m.Todo, error) {
+	todos, _, err := s.GetFiltered(ctx, userId, m.TodoFilter{})
+	return todos, err
+}
+
+/* Retrieves a single todo. Takes the Todo id (int) and userId (string) and returns the Todo (m.Todo) and an error. */
+func (s *memoryService) Get(ctx context.Context, id int, userId string) (m.Todo, error) {
+	if err := s.lock(ctx); err != nil {
+		return m.Todo{}, err
+	}
+	defer s.unlock()
+
+	t := s.ownedTodo(id, userId)
+	if t == nil {
+		return m.Todo{}, ErrNotFound
+	}
+
+	return s.todo(t), nil
+}
+
+// Values todos are sorted by, matching sortExpressions
+var memorySortValues = map[string]func(*memoryTodo) any{
+	m.SortPosition: func(t *memoryTodo) any { return t.position },
+	m.SortPriority: func(t *memoryTodo) any { return int(t.priority) },
+	m.SortDueAt:    func(t *memoryTodo) any { return sortTime(t.dueAt) },
+	m.SortStartAt:  func(t *memoryTodo) any { return sortTime(t.startAt) },
+	m.SortTitle:    func(t *memoryTodo) any { return strings.ToLower(t.title) },
+	m.SortCreated:  func(t *memoryTodo) any { return t.id },
+}
+
+/* Retrieves todos matching a filter, sorted and paginated as requested. Takes the userId (string) and a TodoFilter and returns an array of Todos ([]m.Todo), the cursor of the next page (string, empty on the last page) and an error. */
+func (s *memoryService) GetFiltered(ctx context.Context, userId string, filter m.TodoFilter) ([]m.Todo, string, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, "", err
+	}
+	defer s.unlock()
+
+	sortKey := filter.Sort
+	if sortKey == "" {
+		sortKey = m.SortPosition
+	}
+	sortValue, ok := memorySortValues[sortKey]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: unknown sort key %q", ErrInvalidFilter, sortKey)
+	}
+
+	// Compares todos by sort value, then id, in the requested direction
+	compare := func(value any, id int, t *memoryTodo) int {
+		c := compareValues(value, sortValue(t))
+		if c == 0 {
+			c = cmp.Compare(id, t.id)
+		}
+		if filter.Desc {
+			return -c
+		}
+		return c
+	}
+
+	var after *cursor
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil || c.Sort != sortKey || c.Desc != filter.Desc {
+			return nil, "", fmt.Errorf("%w: cursor does not match this query", ErrInvalidFilter)
+		}
+		after = &c
+	}
+
+	matches := []*memoryTodo{}
+	for _, t := range s.todos {
+		if t.userId != userId || t.deletedAt != nil || !s.matches(t, filter) {
+			continue
+		}
+		// Keyset pagination: continue strictly after the last row of the previous page
+		if after != nil && compare(after.Value, after.ID, t) >= 0 {
+			continue
+		}
+		matches = append(matches, t)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return compare(sortValue(matches[i]), matches[i].id, matches[j]) < 0
+	})
+
+	var next string
+	if filter.Limit > 0 && len(matches) > filter.Limit {
+		matches = matches[:filter.Limit]
+		last := matches[len(matches)-1]
+		next = encodeCursor(cursor{Sort: sortKey, Desc: filter.Desc, Value: sortValue(last), ID: last.id})
+	}
+
+	return s.todoList(matches), next, nil
+}
+
+/* Reports whether a todo passes the conditions of a filter. */
+func (s *memoryService) matches(t *memoryTodo, filter m.TodoFilter) bool {
+	if filter.ListId != nil && (t.listId == nil || *t.listId != *filter.ListId) {
+		return false
+	}
+	if filter.Done != nil && t.done != *filter.Done {
+		return false
+	}
+	if !inRange(t.dueAt, filter.DueFrom, filter.DueBefore) || !inRange(t.startAt, filter.StartFrom, filter.StartBefore) {
+		return false
+	}
+	if filter.Text != "" && !containsFold(t.title, filter.Text) && !containsFold(t.description, filter.Text) {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !s.hasTag(t, tag) {
+			return false
+		}
+	}
+	for _, tag := range filter.ExcludedTags {
+		if s.hasTag(t, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/* Deprecated: toggles todo between done and not done depending on current status, use SetDone. Takes the Todo id (int64), userId (string), completeChildren (bool) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) MarkDone(ctx context.Context, id int64, userId string, completeChildren bool, version int) error {
+	return s.write(ctx, func() error {
+		t, err := s.versionedTodo(int(id), userId, version)
+		if err != nil {
+			return err
+		}
+
+		return s.setDone(t, !t.done, completeChildren)
+	})
+}
+
+/* Marks todo as done or not done. Setting the state it already has changes nothing, so the call is safe to retry. When completeChildren is set and the todo is done, all of its subtasks are completed too. Takes the Todo id (int64), userId (string), done (bool), completeChildren (bool) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) SetDone(ctx context.Context, id int64, userId string, done bool, completeChildren bool, version int) error {
+	return s.write(ctx, func() error {
+		t, err := s.versionedTodo(int(id), userId, version)
+		if err != nil {
+			return err
+		}
+
+		return s.setDone(t, done, completeChildren)
+	})
+}
+
+func (s *memoryService) setDone(t *memoryTodo, done bool, completeChildren bool) error {
+	// A todo already in the requested state is left alone, keeping its
+	// version, its subtasks and its series
+	if t.done == done {
+		return nil
+	}
+
+	t.completedAt = nil
+	if done {
+		t.completedAt = now()
+	}
+	t.done = done
+	bump(t)
+
+	if !done {
+		return nil
+	}
+
+	if completeChildren {
+		for _, d := range s.descendants(t.id) {
+			if d.userId == t.userId && !d.done {
+				d.done = true
+				d.completedAt = now()
+				bump(d)
+			}
+		}
+	}
+
+	// Completing an occurrence of a recurring todo schedules the next one
+	return s.createNextOccurrence(t)
+}
+
+/* Retrieves the direct subtasks of a todo. Takes the Todo id (int) and userId (string) and returns an array of Todos ([]m.Todo) and an error. */
+func (s *memoryService) GetChildren(ctx context.Context, id int, userId string) ([]m.Todo, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	if s.ownedTodo(id, userId) == nil {
+		return nil, ErrNotFound
+	}
+
+	children := []*memoryTodo{}
+	for _, t := range s.todos {
+		if t.parentId != nil && *t.parentId == id && t.userId == userId && t.deletedAt == nil {
+			children = append(children, t)
+		}
+	}
+	sortByPosition(children)
+
+	return s.todoList(children), nil
+}
+
+/* Makes a todo a subtask of another, or a top level todo when parentId is nil. Takes the Todo id (int), parent id (*int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) SetParent(ctx context.Context, id int, parentId *int, userId string, version int) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if parentId != nil {
+		if s.ownedTodo(*parentId, userId) == nil {
+			return ErrNotFound
+		}
+
+		// Walk up from the new parent; finding the todo itself means the move would create a cycle
+		for ancestor := parentId; ancestor != nil; {
+			if *ancestor == id {
+				return ErrCycle
+			}
+			parent := s.todos[*ancestor]
+			if parent == nil {
+				break
+			}
+			ancestor = parent.parentId
+		}
+	}
+
+	t, err := s.versionedTodo(id, userId, version)
+	if err != nil {
+		return err
+	}
+
+	t.parentId = copyInt(parentId)
+	bump(t)
+	return nil
+}
+
+/* Moves a recurring todo to its next occurrence without completing it. Takes the Todo id (int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) SkipOccurrence(ctx context.Context, id int, userId string, version int) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	occ, t, err := s.occurrence(id, userId)
+	if err != nil {
+		return err
+	}
+
+	next, err := s.nextDue(occ, userId)
+	if err != nil {
+		return err
+	}
+	if next.IsZero() {
+		return ErrSeriesFinished
+	}
+	if version != 0 && t.version != version {
+		return ErrVersionConflict
+	}
+
+	t.dueAt = storedTime(&next)
+	t.startAt = storedTime(shiftStart(occ, next))
+	bump(t)
+	return nil
+}
+
+/* Stops a series from generating further occurrences. The todo itself is kept, no longer recurring. Takes the Todo id (int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) EndSeries(ctx context.Context, id int, userId string, version int) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	occ, t, err := s.occurrence(id, userId)
+	if err != nil {
+		return err
+	}
+	if version != 0 && t.version != version {
+		return ErrVersionConflict
+	}
+
+	bump(t)
+	s.series[int(occ.seriesId.Int64)].ended = true
+	return nil
+}
+
+/* Searches the titles and bodies of a user's todos for the query as a plain substring, ignoring case. Takes the userId (string), the query (string) and a limit (int) and returns the matches in position order ([]m.SearchResult) and an error. */
+func (s *memoryService) Search(ctx context.Context, userId string, query string, limit int) ([]m.SearchResult, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	matches := []*memoryTodo{}
+	for _, t := range s.todos {
+		if t.userId == userId && t.deletedAt == nil && (containsFold(t.title, query) || containsFold(t.description, query)) {
+			matches = append(matches, t)
+		}
+	}
+	sortByPosition(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	results := make([]m.SearchResult, len(matches))
+	for i, t := range matches {
+		results[i].Todo = s.todo(t)
+		results[i].Highlights.Title = highlight(t.title)
+		results[i].Highlights.Body = highlight(t.description)
+	}
+
+	return results, nil
+}
+
+/* Creates new Todo. Takes a Todo struct and returns an id (int) and an error. */
+func (s *memoryService) Create(ctx context.Context, todo m.NewTodo, userId string) (int, error) {
+	var id int
+	err := s.write(ctx, func() error {
+		var err error
+		id, err = s.create(todo, userId)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+func (s *memoryService) create(todo m.NewTodo, userId string) (int, error) {
+	if todo.Recurrence != "" {
+		if _, err := validateRecurrence(todo.Recurrence, todo.DueAt); err != nil {
+			return -1, err
+		}
+	}
+
+	// Todos without a list go to their parent's list or the user's inbox
+	var listId int
+	var err error
+	if todo.ListId != nil {
+		listId, err = *todo.ListId, s.checkListOwner(*todo.ListId, userId)
+	} else if todo.ParentId != nil {
+		listId, err = s.todoListId(*todo.ParentId, userId)
+	} else {
+		listId = s.inboxId(userId)
+	}
+	if err != nil {
+		return -1, err
+	}
+	if todo.ParentId != nil && s.todos[*todo.ParentId] == nil {
+		return -1, errForeignKey
+	}
+
+	// New todos are appended to the end of the user's list
+	position := 0.0
+	for i, t := range s.userTodos(userId) {
+		if i == 0 || t.position > position {
+			position = t.position
+		}
+	}
+
+	id := s.nextId("todos")
+	s.todos[id] = &memoryTodo{
+		id:          id,
+		title:       todo.Title,
+		description: todo.Description,
+		userId:      userId,
+		priority:    todo.Priority,
+		position:    position + 1,
+		listId:      &listId,
+		parentId:    copyInt(todo.ParentId),
+		dueAt:       storedTime(todo.DueAt),
+		startAt:     storedTime(todo.StartAt),
+		version:     1,
+		updatedAt:   now(),
+	}
+
+	s.setTodoTags(id, todo.Tags, userId)
+
+	if todo.Recurrence != "" {
+		if err := s.setRecurrence(id, todo.Recurrence, todo.DueAt, userId); err != nil {
+			return -1, err
+		}
+	}
+
+	return id, nil
+}
+
+/* Edit Todo, replacing its title, body, priority and dates. The list, tags and recurrence are kept when newData leaves them out. Takes the Todo id (int), a NewTodo struct, userId (string) and the version (int) the edit was based on, or 0 to overwrite any version, and returns an error. */
+func (s *memoryService) Edit(ctx context.Context, id int, newData m.NewTodo, userId string, version int) error {
+	return s.write(ctx, func() error {
+		if newData.Recurrence != "" {
+			if _, err := validateRecurrence(newData.Recurrence, newData.DueAt); err != nil {
+				return err
+			}
+		}
+
+		// The list is only changed when the edit includes one
+		if newData.ListId != nil {
+			if err := s.checkListOwner(*newData.ListId, userId); err != nil {
+				return err
+			}
+		}
+
+		t, err := s.versionedTodo(id, userId, version)
+		if err != nil {
+			return err
+		}
+
+		t.title = newData.Title
+		t.description = newData.Description
+		t.priority = newData.Priority
+		if newData.ListId != nil {
+			t.listId = copyInt(newData.ListId)
+		}
+		t.dueAt = storedTime(newData.DueAt)
+		t.startAt = storedTime(newData.StartAt)
+		bump(t)
+
+		// Tags are only replaced when the edit includes them
+		if newData.Tags != nil {
+			s.setTodoTags(id, newData.Tags, userId)
+		}
+
+		// The recurrence rule is only replaced when the edit includes one
+		if newData.Recurrence != "" {
+			return s.setRecurrence(id, newData.Recurrence, newData.DueAt, userId)
+		}
+
+		return nil
+	})
+}
+
+/* Moves todo between two neighbours by giving it a position halfway between theirs. Takes the Todo id (int), the neighbours (m.MoveTodo), userId (string) and the version (int) the move was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) Move(ctx context.Context, id int, move m.MoveTodo, userId string, version int) error {
+	return s.write(ctx, func() error {
+		low, high, err := s.neighbourPositions(id, move, userId)
+		if err != nil {
+			return err
+		}
+
+		t, err := s.versionedTodo(id, userId, version)
+		if err != nil {
+			return err
+		}
+
+		// Renumber once positions get too close, as the SQL implementation does
+		if high-low < minPositionGap {
+			s.renumberPositions(userId)
+			if low, high, err = s.neighbourPositions(id, move, userId); err != nil {
+				return err
+			}
+		}
+
+		t.position = low + (high-low)/2
+		bump(t)
+		return nil
+	})
+}
+
+/* Returns the positions a moved todo must fall between. When only one neighbour is given, the other is the todo next to it in the list, or one step past it at either end. */
+func (s *memoryService) neighbourPositions(id int, move m.MoveTodo, userId string) (float64, float64, error) {
+	var low, high float64
+
+	if move.After == nil && move.Before == nil {
+		return 0, 0, ErrInvalidMove
+	}
+	if (move.After != nil && *move.After == id) || (move.Before != nil && *move.Before == id) {
+		return 0, 0, ErrInvalidMove
+	}
+
+	if move.After != nil {
+		after := s.ownedTodo(*move.After, userId)
+		if after == nil {
+			return 0, 0, ErrNotFound
+		}
+		low = after.position
+	}
+	if move.Before != nil {
+		before := s.ownedTodo(*move.Before, userId)
+		if before == nil {
+			return 0, 0, ErrNotFound
+		}
+		high = before.position
+	}
+
+	if move.Before == nil {
+		high = math.Inf(1)
+		for _, t := range s.userTodos(userId) {
+			if t.deletedAt == nil && t.position > low && t.id != id {
+				high = min(high, t.position)
+			}
+		}
+		if math.IsInf(high, 1) {
+			high = low + 1
+		}
+	}
+	if move.After == nil {
+		low = math.Inf(-1)
+		for _, t := range s.userTodos(userId) {
+			if t.deletedAt == nil && t.position < high && t.id != id {
+				low = max(low, t.position)
+			}
+		}
+		if math.IsInf(low, -1) {
+			low = high - 1
+		}
+	}
+
+	if low >= high {
+		return 0, 0, ErrInvalidMove
+	}
+
+	return low, high, nil
+}
+
+/* Resets the positions of a user's todos to consecutive integers, keeping their current order. */
+func (s *memoryService) renumberPositions(userId string) {
+	todos := s.userTodos(userId)
+	sortByPosition(todos)
+	for i, t := range todos {
+		t.position = float64(i + 1)
+	}
+}
+
+/* Moves todo to the trash together with its subtasks, so purging the todo cannot take live subtasks with it. Takes the Todo id (int), userId (string) and the version (int) the delete was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) Delete(ctx context.Context, id int, userId string, version int) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	t, err := s.versionedTodo(id, userId, version)
+	if err != nil {
+		return err
+	}
+
+	s.trash(t)
+	return nil
+}
+
+/* Retrieves trashed todos, most recently trashed first. Takes the userId (string) and returns an array of Todos ([]m.Todo) and an error. */
+func (s *memoryService) GetTrash(ctx context.Context, userId string) ([]m.Todo, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	trashed := []*memoryTodo{}
+	for _, t := range s.userTodos(userId) {
+		if t.deletedAt != nil {
+			trashed = append(trashed, t)
+		}
+	}
+	sort.Slice(trashed, func(i, j int) bool {
+		if !trashed[i].deletedAt.Equal(*trashed[j].deletedAt) {
+			return trashed[i].deletedAt.After(*trashed[j].deletedAt)
+		}
+		return trashed[i].id > trashed[j].id
+	})
+
+	return s.todoList(trashed), nil
+}
+
+/* Restores todo from the trash together with its trashed subtasks. A todo whose parent is still in the trash becomes a top level todo. Takes the Todo id (int) and userId (string) and returns an error. */
+func (s *memoryService) Restore(ctx context.Context, id int, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	t := s.todos[id]
+	if t == nil || t.userId != userId || t.deletedAt == nil {
+		return ErrNotFound
+	}
+
+	if t.parentId != nil {
+		if parent := s.todos[*t.parentId]; parent != nil && parent.deletedAt != nil {
+			t.parentId = nil
+		}
+	}
+
+	for _, d := range append(s.trashedDescendants(id), t) {
+		d.deletedAt = nil
+		bump(d)
+	}
+	return nil
+}
+
+/* Permanently removes todos that have been in the trash longer than the retention period in days. Returns the number of purged todos and an error. */
+func (s *memoryService) PurgeTrash(ctx context.Context, retentionDays int) (int64, error) {
+	if err := s.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.unlock()
+
+	cutoff := storedTime(ptr(time.Now().AddDate(0, 0, -retentionDays)))
+	purged := []int{}
+	for id, t := range s.todos {
+		if t.deletedAt != nil && t.deletedAt.Before(*cutoff) {
+			purged = append(purged, id)
+		}
+	}
+	for _, id := range purged {
+		s.deleteTodo(id)
+	}
+
+	return int64(len(purged)), nil
+}
+
+/* Retrieves all tags of a user ordered by name. Takes the userId (string) and returns an array of Tags ([]m.Tag) and an error. */
+func (s *memoryService) GetTags(ctx context.Context, userId string) ([]m.Tag, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	tags := []m.Tag{}
+	for _, tag := range s.tags {
+		if tag.userId == userId {
+			tags = append(tags, tag.Tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	return tags, nil
+}
+
+/* Creates new Tag. Takes a NewTag struct and userId (string) and returns an id (int) and an error. */
+func (s *memoryService) CreateTag(ctx context.Context, tag m.NewTag, userId string) (int, error) {
+	if err := s.lock(ctx); err != nil {
+		return -1, err
+	}
+	defer s.unlock()
+
+	if s.tagByName(tag.Name, userId) != nil {
+		return -1, ErrTagExists
+	}
+
+	return s.createTag(tag, userId), nil
+}
+
+/* Renames or recolours a Tag. Takes the Tag id (int), a NewTag struct and userId (string) and returns an error. */
+func (s *memoryService) EditTag(ctx context.Context, id int, tag m.NewTag, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	t := s.tags[id]
+	if t == nil || t.userId != userId {
+		return ErrNotFound
+	}
+	if other := s.tagByName(tag.Name, userId); other != nil && other.ID != id {
+		return ErrTagExists
+	}
+
+	t.Name = tag.Name
+	t.Color = tag.Color
+	return nil
+}
+
+/* Deletes a Tag. Todos keep existing and lose the tag. Takes the Tag id (int) and userId (string) and returns an error. */
+func (s *memoryService) DeleteTag(ctx context.Context, id int, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	t := s.tags[id]
+	if t == nil || t.userId != userId {
+		return ErrNotFound
+	}
+
+	delete(s.tags, id)
+	for _, tagIds := range s.todoTags {
+		delete(tagIds, id)
+	}
+	return nil
+}
+
+/* Retrieves the lists of a user in position order. Takes the userId (string) and whether to include archived lists (bool) and returns an array of Lists ([]m.List) and an error. */
+func (s *memoryService) GetLists(ctx context.Context, userId string, includeArchived bool) ([]m.List, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	lists := []m.List{}
+	for _, list := range s.lists {
+		if list.userId == userId && (includeArchived || !list.Archived) {
+			lists = append(lists, list.List)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Position != lists[j].Position {
+			return lists[i].Position < lists[j].Position
+		}
+		return lists[i].ID < lists[j].ID
+	})
+
+	return lists, nil
+}
+
+/* Creates new List at the end of the user's lists unless a position is given. Takes a NewList struct and userId (string) and returns an id (int) and an error. */
+func (s *memoryService) CreateList(ctx context.Context, list m.NewList, userId string) (int, error) {
+	if err := s.lock(ctx); err != nil {
+		return -1, err
+	}
+	defer s.unlock()
+
+	var position float64
+	if list.Position != nil {
+		position = *list.Position
+	} else {
+		found := false
+		for _, l := range s.lists {
+			if l.userId == userId && (!found || l.Position > position) {
+				position, found = l.Position, true
+			}
+		}
+		position++
+	}
+
+	id := s.nextId("lists")
+	s.lists[id] = &memoryList{
+		List:   m.List{ID: id, Name: list.Name, Color: list.Color, Archived: list.Archived != nil && *list.Archived, Position: position},
+		userId: userId,
+	}
+
+	return id, nil
+}
+
+/* Edits a List. Takes the List id (int), a NewList struct and userId (string) and returns an error. */
+func (s *memoryService) EditList(ctx context.Context, id int, list m.NewList, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if list.Archived != nil && *list.Archived {
+		if err := s.checkNotInbox(id, userId); err != nil {
+			return err
+		}
+	}
+
+	l := s.lists[id]
+	if l == nil || l.userId != userId {
+		return ErrNotFound
+	}
+
+	l.Name = list.Name
+	l.Color = list.Color
+	if list.Archived != nil {
+		l.Archived = *list.Archived
+	}
+	if list.Position != nil {
+		l.Position = *list.Position
+	}
+	return nil
+}
+
+/* Deletes a List. Its todos go to the trash, and to the inbox so they have a list to be restored to. The inbox cannot be deleted. Takes the List id (int) and userId (string) and returns an error. */
+func (s *memoryService) DeleteList(ctx context.Context, id int, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if err := s.checkNotInbox(id, userId); err != nil {
+		return err
+	}
+
+	inboxId := s.inboxId(userId)
+	for _, t := range s.userTodos(userId) {
+		if t.listId == nil || *t.listId != id {
+			continue
+		}
+		if t.deletedAt == nil {
+			s.trash(t)
+		}
+		t.listId = &inboxId
+	}
+
+	delete(s.lists, id)
+	return nil
+}
+
+/* Moves a todo to another of the user's lists. Takes the Todo id (int), List id (int), userId (string) and the version (int) the move was based on, or 0 for any version, and returns an error. */
+func (s *memoryService) MoveToList(ctx context.Context, id int, listId int, userId string, version int) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if err := s.checkListOwner(listId, userId); err != nil {
+		return err
+	}
+
+	t, err := s.versionedTodo(id, userId, version)
+	if err != nil {
+		return err
+	}
+
+	t.listId = &listId
+	bump(t)
+	return nil
+}
+
+/* Saves a user upon successful login, creating the user and their inbox list on first login. */
+func (s *memoryService) SaveUser(ctx context.Context, user goth.User) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if _, ok := s.users[user.UserID]; !ok {
+		s.users[user.UserID] = &memoryUser{user: user, timeZone: "UTC"}
+		s.inboxId(user.UserID)
+	}
+	return nil
+}
+
+/* Creates a new Session, ending the oldest sessions of its user beyond maxSessions unless it is zero. Takes a Session struct with the token hash, user, data, client and expiry set and maxSessions (int) and returns an id (int) and an error. */
+func (s *memoryService) CreateSession(ctx context.Context, session m.Session, maxSessions int) (int, error) {
+	if err := s.lock(ctx); err != nil {
+		return -1, err
+	}
+	defer s.unlock()
+
+	if _, ok := s.users[session.UserId]; !ok {
+		return -1, errForeignKey
+	}
+	if s.sessionByToken(session.TokenHash) != nil {
+		return -1, errSessionExists
+	}
+
+	session.ID = s.nextId("sessions")
+	session.CreatedAt = *now()
+	session.LastSeenAt = session.CreatedAt
+	session.ExpiresAt = *storedTime(&session.ExpiresAt)
+	session.Current = false
+	s.sessions[session.ID] = &session
+
+	if maxSessions > 0 {
+		s.evictSessions(session.UserId, maxSessions)
+	}
+
+	return session.ID, nil
+}
+
+/* Retrieves an unexpired Session. Takes the hash of its token (string) and returns a Session and an error. */
+func (s *memoryService) GetSession(ctx context.Context, tokenHash string) (m.Session, error) {
+	if err := s.lock(ctx); err != nil {
+		return m.Session{}, err
+	}
+	defer s.unlock()
+
+	session := s.sessionByToken(tokenHash)
+	if session == nil || !session.ExpiresAt.After(*now()) {
+		return m.Session{}, ErrNotFound
+	}
+
+	return *session, nil
+}
+
+/* Saves the data of a Session and records it as seen now from its address and browser. Takes a Session struct and returns an error. */
+func (s *memoryService) UpdateSession(ctx context.Context, session m.Session) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok || stored.UserId != session.UserId || !stored.ExpiresAt.After(*now()) {
+		return ErrNotFound
+	}
+
+	stored.Data = session.Data
+	stored.IP = session.IP
+	stored.UserAgent = session.UserAgent
+	stored.LastSeenAt = *now()
+	return nil
+}
+
+/* Retrieves the unexpired sessions of a user, most recently seen first. Takes the userId (string) and returns an array of Sessions ([]m.Session) and an error. */
+func (s *memoryService) GetSessions(ctx context.Context, userId string) ([]m.Session, error) {
+	if err := s.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer s.unlock()
+
+	sessions := []m.Session{}
+	for _, session := range s.sessions {
+		if session.UserId == userId && session.ExpiresAt.After(*now()) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+/* Ends a Session. Takes the Session id (int) and userId (string) and returns an error. */
+func (s *memoryService) DeleteSession(ctx context.Context, id int, userId string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.UserId != userId {
+		return ErrNotFound
+	}
+
+	delete(s.sessions, id)
+	return nil
+}
+
+/* Ends every session of a user but one. Takes the id of the Session to keep (int) and userId (string) and returns the number of sessions ended (int64) and an error. */
+func (s *memoryService) DeleteOtherSessions(ctx context.Context, keepId int, userId string) (int64, error) {
+	if err := s.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.unlock()
+
+	var n int64
+	for id, session := range s.sessions {
+		if session.UserId == userId && id != keepId && session.ExpiresAt.After(*now()) {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+/* Deletes expired sessions. Returns the number of sessions deleted (int64) and an error. */
+func (s *memoryService) PurgeSessions(ctx context.Context) (int64, error) {
+	if err := s.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer s.unlock()
+
+	var n int64
+	for id, session := range s.sessions {
+		if !session.ExpiresAt.After(*now()) {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+/* Deletes the sessions of a user but the newest unexpired ones, keeping at most maxSessions. */
+func (s *memoryService) evictSessions(userId string, maxSessions int) {
+	kept := []*m.Session{}
+	for _, session := range s.sessions {
+		if session.UserId == userId && session.ExpiresAt.After(*now()) {
+			kept = append(kept, session)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if !kept[i].CreatedAt.Equal(kept[j].CreatedAt) {
+			return kept[i].CreatedAt.After(kept[j].CreatedAt)
+		}
+		return kept[i].ID > kept[j].ID
+	})
+
+	keep := map[int]bool{}
+	for _, session := range kept[:min(len(kept), maxSessions)] {
+		keep[session.ID] = true
+	}
+	for id, session := range s.sessions {
+		if session.UserId == userId && !keep[id] {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+/* Returns the session with a token hash, expired or not, or nil. */
+func (s *memoryService) sessionByToken(tokenHash string) *m.Session {
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return session
+		}
+	}
+	return nil
+}
+
+/* Retrieves the IANA time zone name of a user. Takes the userId (string) and returns the time zone (string) and an error. */
+func (s *memoryService) GetTimeZone(ctx context.Context, userId string) (string, error) {
+	if err := s.lock(ctx); err != nil {
+		return "", err
+	}
+	defer s.unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return user.timeZone, nil
+}
+
+/* Sets the IANA time zone name of a user. Takes the userId (string) and time zone (string) and returns an error. */
+func (s *memoryService) SetTimeZone(ctx context.Context, userId string, timeZone string) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if user, ok := s.users[userId]; ok {
+		user.timeZone = timeZone
+	}
+	return nil
+}
+
+/* Locks the database unless the context is already done, in which case its error is returned. Inside a transaction the lock is already held. */
+func (s *memoryService) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !s.inTx {
+		s.mu.Lock()
+	}
+	return nil
+}
+
+/* Unlocks the database, unless the lock belongs to a transaction. */
+func (s *memoryService) unlock() {
+	if !s.inTx {
+		s.mu.Unlock()
+	}
+}
+
+/* Runs fn holding the lock and restores every table when it fails, so a call making several changes makes all of them or none, as a SQL transaction does. Inside a transaction WithTx does both. */
+func (s *memoryService) write(ctx context.Context, fn func() error) error {
+	if err := s.lock(ctx); err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	if s.inTx {
+		return fn()
+	}
+
+	saved := s.memoryTables.clone()
+	if err := fn(); err != nil {
+		s.memoryTables = saved
+		return err
+	}
+	return nil
+}
+
+/* Returns a copy of the tables that later changes do not affect. */
+func (t memoryTables) clone() memoryTables {
+	todoTags := make(map[int]map[int]bool, len(t.todoTags))
+	for id, tagIds := range t.todoTags {
+		todoTags[id] = maps.Clone(tagIds)
+	}
+
+	return memoryTables{
+		users:    clonePointers(t.users),
+		sessions: clonePointers(t.sessions),
+		lists:    clonePointers(t.lists),
+		series:   clonePointers(t.series),
+		todos:    clonePointers(t.todos),
+		tags:     clonePointers(t.tags),
+		todoTags: todoTags,
+		lastIds:  maps.Clone(t.lastIds),
+	}
+}
+
+/* Copies a map along with the values its pointers point to. */
+func clonePointers[K comparable, V any](m map[K]*V) map[K]*V {
+	c := make(map[K]*V, len(m))
+	for k, v := range m {
+		c[k] = ptr(*v)
+	}
+	return c
+}
+
+/* Returns the next id of a table. */
+func (s *memoryService) nextId(table string) int {
+	s.lastIds[table]++
+	return s.lastIds[table]
+}
+
+/* Returns one of the user's todos that is not in the trash, or nil. */
+func (s *memoryService) ownedTodo(id int, userId string) *memoryTodo {
+	t := s.todos[id]
+	if t == nil || t.userId != userId || t.deletedAt != nil {
+		return nil
+	}
+	return t
+}
+
+/* Returns one of the user's todos that is not in the trash, ErrNotFound if there is none, or ErrVersionConflict when version is set and the todo has another. */
+func (s *memoryService) versionedTodo(id int, userId string, version int) (*memoryTodo, error) {
+	t := s.ownedTodo(id, userId)
+	if t == nil {
+		return nil, ErrNotFound
+	}
+	if version != 0 && t.version != version {
+		return nil, ErrVersionConflict
+	}
+	return t, nil
+}
+
+/* Returns every todo of a user, including trashed ones. */
+func (s *memoryService) userTodos(userId string) []*memoryTodo {
+	todos := []*memoryTodo{}
+	for _, t := range s.todos {
+		if t.userId == userId {
+			todos = append(todos, t)
+		}
+	}
+	return todos
+}
+
+/* Returns the todos as the API sees them. */
+func (s *memoryService) todoList(todos []*memoryTodo) []m.Todo {
+	list := make([]m.Todo, len(todos))
+	for i, t := range todos {
+		list[i] = s.todo(t)
+	}
+	return list
+}
+
+/* Returns the todo as the API sees it, with its tags, active recurrence rule and subtask counts. */
+func (s *memoryService) todo(t *memoryTodo) m.Todo {
+	todo := m.Todo{
+		ID:          t.id,
+		Title:       t.title,
+		Done:        t.done,
+		Body:        t.description,
+		Priority:    t.priority,
+		Position:    t.position,
+		Tags:        s.tagNames(t.id),
+		ListId:      copyInt(t.listId),
+		ParentId:    copyInt(t.parentId),
+		SeriesId:    copyInt(t.seriesId),
+		Version:     t.version,
+		UpdatedAt:   storedTime(t.updatedAt),
+		DueAt:       storedTime(t.dueAt),
+		StartAt:     storedTime(t.startAt),
+		CompletedAt: storedTime(t.completedAt),
+		DeletedAt:   storedTime(t.deletedAt),
+	}
+
+	if t.seriesId != nil {
+		if series := s.series[*t.seriesId]; series != nil && !series.ended {
+			todo.Recurrence = series.rule
+		}
+	}
+
+	for _, c := range s.todos {
+		if c.parentId != nil && *c.parentId == t.id && c.deletedAt == nil {
+			todo.ChildrenTotal++
+			if c.done {
+				todo.ChildrenDone++
+			}
+		}
+	}
+
+	return todo
+}
+
+/* Returns every subtask below a todo that is not in the trash, at any depth. */
+func (s *memoryService) descendants(id int) []*memoryTodo {
+	var found []*memoryTodo
+	for _, t := range s.todos {
+		if t.parentId != nil && *t.parentId == id && t.deletedAt == nil {
+			found = append(found, t)
+			found = append(found, s.descendants(t.id)...)
+		}
+	}
+	return found
+}
+
+/* Moves a todo to the trash together with its subtasks. */
+func (s *memoryService) trash(t *memoryTodo) {
+	deletedAt := now()
+	for _, d := range append(s.descendants(t.id), t) {
+		d.deletedAt = deletedAt
+		bump(d)
+	}
+}
+
+/* Returns every subtask below a todo that is in the trash, at any depth. */
+func (s *memoryService) trashedDescendants(id int) []*memoryTodo {
+	var found []*memoryTodo
+	for _, t := range s.todos {
+		if t.parentId != nil && *t.parentId == id && t.deletedAt != nil {
+			found = append(found, t)
+			found = append(found, s.trashedDescendants(t.id)...)
+		}
+	}
+	return found
+}
+
+/* Removes a todo for good, together with its subtasks and tags. */
+func (s *memoryService) deleteTodo(id int) {
+	if _, ok := s.todos[id]; !ok {
+		return
+	}
+
+	delete(s.todos, id)
+	delete(s.todoTags, id)
+	for childId, t := range s.todos {
+		if t.parentId != nil && *t.parentId == id {
+			s.deleteTodo(childId)
+		}
+	}
+}
+
+/* Creates the occurrence following a completed recurring todo, copying its details with the dates advanced. Does nothing if the todo is not done, its series has ended or the next occurrence already exists. */
+func (s *memoryService) createNextOccurrence(t *memoryTodo) error {
+	occ, _, err := s.occurrence(t.id, t.userId)
+	if errors.Is(err, ErrNotRecurring) || (err == nil && !occ.done) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Completing, reopening and completing again must not schedule twice
+	for _, o := range s.todos {
+		if o.seriesId != nil && *o.seriesId == *t.seriesId && o.userId == t.userId && o.deletedAt == nil && o.dueAt != nil && o.dueAt.After(*occ.dueAt) {
+			return nil
+		}
+	}
+
+	next, err := s.nextDue(occ, t.userId)
+	if err != nil || next.IsZero() {
+		return err
+	}
+
+	newId, err := s.create(m.NewTodo{
+		Title:       t.title,
+		Description: t.description,
+		Priority:    t.priority,
+		Tags:        s.tagNames(t.id),
+		ListId:      copyInt(t.listId),
+		ParentId:    copyInt(t.parentId),
+		DueAt:       &next,
+		StartAt:     shiftStart(occ, next),
+	}, t.userId)
+	if err != nil {
+		log.Println("error creating next occurrence of recurring todo")
+		return err
+	}
+
+	s.todos[newId].seriesId = copyInt(t.seriesId)
+	return nil
+}
+
+/* Loads the recurrence state of a todo, returning ErrNotRecurring if it is not part of an active series. */
+func (s *memoryService) occurrence(id int, userId string) (occurrence, *memoryTodo, error) {
+	var occ occurrence
+	t := s.ownedTodo(id, userId)
+	if t == nil {
+		return occ, nil, ErrNotFound
+	}
+
+	occ.done = t.done
+	occ.dueAt = storedTime(t.dueAt)
+	occ.startAt = storedTime(t.startAt)
+	if t.seriesId != nil {
+		occ.seriesId = sql.NullInt64{Int64: int64(*t.seriesId), Valid: true}
+	}
+
+	var series *memorySeries
+	if t.seriesId != nil {
+		series = s.series[*t.seriesId]
+	}
+	if series == nil || series.ended || t.dueAt == nil {
+		return occ, t, ErrNotRecurring
+	}
+
+	occ.rule = series.rule
+	occ.seriesAt = series.startAt
+	return occ, t, nil
+}
+
+/* Returns the first occurrence of the series after the due date of occ, evaluated in the user's time zone. */
+func (s *memoryService) nextDue(occ occurrence, userId string) (time.Time, error) {
+	var timeZone string
+	if user, ok := s.users[userId]; ok {
+		timeZone = user.timeZone
+	}
+	return occurrenceAfter(occ, timeZone)
+}
+
+/* Starts a series for a todo, or replaces the rule of the series it belongs to. */
+func (s *memoryService) setRecurrence(id int, rule string, dueAt *time.Time, userId string) error {
+	rule, err := validateRecurrence(rule, dueAt)
+	if err != nil {
+		return err
+	}
+
+	t := s.todos[id]
+	if t == nil || t.userId != userId {
+		return ErrNotFound
+	}
+
+	if t.seriesId != nil {
+		if series := s.series[*t.seriesId]; series != nil && series.userId == userId {
+			series.rule = rule
+			series.startAt = *storedTime(dueAt)
+			series.ended = false
+		}
+		return nil
+	}
+
+	seriesId := s.nextId("series")
+	s.series[seriesId] = &memorySeries{rule: rule, startAt: *storedTime(dueAt), userId: userId}
+	t.seriesId = &seriesId
+	return nil
+}
+
+/* Replaces the tags of a todo with the given tag names, creating tags that do not exist yet. */
+func (s *memoryService) setTodoTags(todoId int, names []string, userId string) {
+	tagIds := map[int]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if tag := s.tagByName(name, userId); tag != nil {
+			tagIds[tag.ID] = true
+		} else {
+			tagIds[s.createTag(m.NewTag{Name: name}, userId)] = true
+		}
+	}
+	s.todoTags[todoId] = tagIds
+}
+
+/* Retrieves the tag names of a todo in order. */
+func (s *memoryService) tagNames(todoId int) []string {
+	names := []string{}
+	for tagId := range s.todoTags[todoId] {
+		names = append(names, s.tags[tagId].Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* Reports whether a todo has the user's tag with the given name. */
+func (s *memoryService) hasTag(t *memoryTodo, name string) bool {
+	tag := s.tagByName(name, t.userId)
+	return tag != nil && s.todoTags[t.id][tag.ID]
+}
+
+/* Returns the user's tag with the given name, or nil. */
+func (s *memoryService) tagByName(name string, userId string) *memoryTag {
+	for _, tag := range s.tags {
+		if tag.userId == userId && tag.Name == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+func (s *memoryService) createTag(tag m.NewTag, userId string) int {
+	id := s.nextId("tags")
+	s.tags[id] = &memoryTag{Tag: m.Tag{ID: id, Name: tag.Name, Color: tag.Color}, userId: userId}
+	return id
+}
+
+/* Returns the id of the user's inbox list, creating it when the user does not have one yet. */
+func (s *memoryService) inboxId(userId string) int {
+	for id, list := range s.lists {
+		if list.userId == userId && list.Inbox {
+			return id
+		}
+	}
+
+	id := s.nextId("lists")
+	s.lists[id] = &memoryList{List: m.List{ID: id, Name: inboxName, Inbox: true}, userId: userId}
+	return id
+}
+
+/* Returns the list of a todo, or ErrNotFound if the user does not own it. */
+func (s *memoryService) todoListId(id int, userId string) (int, error) {
+	t := s.ownedTodo(id, userId)
+	if t == nil {
+		return -1, ErrNotFound
+	}
+
+	if t.listId == nil {
+		return s.inboxId(userId), nil
+	}
+
+	return *t.listId, nil
+}
+
+/* Returns ErrNotFound unless the list exists and belongs to the user. */
+func (s *memoryService) checkListOwner(listId int, userId string) error {
+	if list := s.lists[listId]; list == nil || list.userId != userId {
+		return ErrNotFound
+	}
+	return nil
+}
+
+/* Returns ErrInboxList if the list is the user's inbox. */
+func (s *memoryService) checkNotInbox(listId int, userId string) error {
+	list := s.lists[listId]
+	if list == nil || list.userId != userId {
+		return ErrNotFound
+	}
+	if list.Inbox {
+		return ErrInboxList
+	}
+	return nil
+}
+
+/* Marks a todo as changed. */
+func bump(t *memoryTodo) {
+	t.version++
+	t.updatedAt = now()
+}
+
+/* Returns the current time as it would be stored. */
+func now() *time.Time {
+	return storedTime(ptr(time.Now()))
+}
+
+/* Returns a copy of an optional time as it is stored: in UTC and to the second. */
+func storedTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	return ptr(t.UTC().Truncate(time.Second))
+}
+
+/* Returns the value a date sorts by, missing dates sort after every date. */
+func sortTime(t *time.Time) any {
+	if t == nil {
+		return "9999-12-31 23:59:59"
+	}
+	return formatTime(t)
+}
+
+/* Reports whether an optional time is within [from, before), where unset bounds do not limit. Unset times are only in an unbounded range. */
+func inRange(t *time.Time, from *time.Time, before *time.Time) bool {
+	if from == nil && before == nil {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return (from == nil || !t.Before(*storedTime(from))) && (before == nil || t.Before(*storedTime(before)))
+}
+
+/* Compares two sort values, numbers by value and anything else as text. */
+func compareValues(a any, b any) int {
+	x, xok := toFloat(a)
+	y, yok := toFloat(b)
+	if xok && yok {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+/* Reports whether s contains substr, ignoring case. */
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+/* Sorts todos by position, then id. */
+func sortByPosition(todos []*memoryTodo) {
+	sort.Slice(todos, func(i, j int) bool {
+		if todos[i].position != todos[j].position {
+			return todos[i].position < todos[j].position
+		}
+		return todos[i].id < todos[j].id
+	})
+}
+
+func copyInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	return ptr(*p)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,279 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// step is one call of a scenario, returning what the call returned.
+type step struct {
+	name string
+	call func(ctx context.Context, db Service) (any, error)
+}
+
+// Steps run against both databases, which must return the same for each
+func conformanceSteps() []step {
+	due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	start := due.Add(-2 * time.Hour)
+
+	create := func(userId string, todo m.NewTodo) step {
+		return step{"create " + todo.Title, func(ctx context.Context, db Service) (any, error) {
+			return db.Create(ctx, todo, userId)
+		}}
+	}
+	read := func(name string) step {
+		return step{"read " + name, func(ctx context.Context, db Service) (any, error) {
+			todos, err := db.GetAll(ctx, "u1")
+			if err != nil {
+				return nil, err
+			}
+			trash, err := db.GetTrash(ctx, "u1")
+			if err != nil {
+				return nil, err
+			}
+			lists, err := db.GetLists(ctx, "u1", true)
+			if err != nil {
+				return nil, err
+			}
+			tags, err := db.GetTags(ctx, "u1")
+			return []any{todos, trash, lists, tags}, err
+		}}
+	}
+
+	return []step{
+		create("u1", m.NewTodo{Title: "Buy milk", Description: "at the store", Tags: []string{"home", "errand"}, Recurrence: "FREQ=DAILY", DueAt: &due, StartAt: &start}),
+		create("u1", m.NewTodo{Title: "Report", Priority: m.PriorityHigh}),
+		create("u1", m.NewTodo{Title: "Outline", ParentId: ptr(2)}),
+		create("u1", m.NewTodo{Title: "Draft", ParentId: ptr(3), Tags: []string{"work"}}),
+		create("u2", m.NewTodo{Title: "Not yours"}),
+		create("u1", m.NewTodo{Title: "Missing parent", ParentId: ptr(5)}),
+		create("u1", m.NewTodo{Title: "Bad rule", Recurrence: "FREQ=NEVER", DueAt: &due}),
+		{"create list", func(ctx context.Context, db Service) (any, error) {
+			return db.CreateList(ctx, m.NewList{Name: "work", Color: "#ff0000"}, "u1")
+		}},
+		{"archive list", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EditList(ctx, 3, m.NewList{Name: "work", Archived: ptr(true)}, "u1")
+		}},
+		{"rename list", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EditList(ctx, 3, m.NewList{Name: "office", Color: "#ff0000"}, "u1")
+		}},
+		{"lists", func(ctx context.Context, db Service) (any, error) {
+			return db.GetLists(ctx, "u1", true)
+		}},
+		{"unarchive list", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EditList(ctx, 3, m.NewList{Name: "work", Color: "#ff0000", Archived: ptr(false)}, "u1")
+		}},
+		{"move to list", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.MoveToList(ctx, 2, 3, "u1", 1)
+		}},
+		create("u1", m.NewTodo{Title: "Slides", ListId: ptr(3)}),
+		{"move", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Move(ctx, 6, m.MoveTodo{After: ptr(1), Before: ptr(2)}, "u1", 0)
+		}},
+		{"move next to itself", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Move(ctx, 6, m.MoveTodo{After: ptr(6)}, "u1", 0)
+		}},
+		{"move a stale version", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Move(ctx, 6, m.MoveTodo{Before: ptr(1)}, "u1", 1)
+		}},
+		{"set parent in a cycle", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SetParent(ctx, 2, ptr(4), "u1", 0)
+		}},
+		{"set parent", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SetParent(ctx, 6, ptr(2), "u1", 0)
+		}},
+		{"edit", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Edit(ctx, 2, m.NewTodo{Title: "Quarterly report", Priority: m.PriorityUrgent, Tags: []string{"work"}}, "u1", 2)
+		}},
+		{"edit a stale version", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Edit(ctx, 2, m.NewTodo{Title: "stale"}, "u1", 2)
+		}},
+		{"edit another user's todo", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Edit(ctx, 5, m.NewTodo{Title: "mine"}, "u1", 0)
+		}},
+		read("after edits"),
+		{"complete occurrence", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SetDone(ctx, 1, "u1", true, false, 0)
+		}},
+		{"complete occurrence again", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SetDone(ctx, 1, "u1", true, false, 0)
+		}},
+		{"skip occurrence", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SkipOccurrence(ctx, 7, "u1", 0)
+		}},
+		{"skip a todo that does not recur", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SkipOccurrence(ctx, 2, "u1", 0)
+		}},
+		{"complete with subtasks", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SetDone(ctx, 2, "u1", true, true, 0)
+		}},
+		{"toggle", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.MarkDone(ctx, 4, "u1", false, 0)
+		}},
+		read("after completing"),
+		{"children", func(ctx context.Context, db Service) (any, error) {
+			return db.GetChildren(ctx, 2, "u1")
+		}},
+		{"filter", func(ctx context.Context, db Service) (any, error) {
+			done := true
+			todos, next, err := db.GetFiltered(ctx, "u1", m.TodoFilter{Done: &done, Sort: m.SortTitle, Desc: true, Limit: 2})
+			return []any{todos, next}, err
+		}},
+		{"filter the next page", func(ctx context.Context, db Service) (any, error) {
+			done := true
+			first, next, err := db.GetFiltered(ctx, "u1", m.TodoFilter{Done: &done, Sort: m.SortTitle, Desc: true, Limit: 2})
+			if err != nil {
+				return nil, err
+			}
+			todos, next, err := db.GetFiltered(ctx, "u1", m.TodoFilter{Done: &done, Sort: m.SortTitle, Desc: true, Limit: 2, Cursor: next})
+			return []any{first, todos, next}, err
+		}},
+		{"filter by tags", func(ctx context.Context, db Service) (any, error) {
+			todos, _, err := db.GetFiltered(ctx, "u1", m.TodoFilter{Tags: []string{"work"}, ExcludedTags: []string{"home"}, Text: "R"})
+			return todos, err
+		}},
+		{"search", func(ctx context.Context, db Service) (any, error) {
+			return db.Search(ctx, "u1", "MILK", 10)
+		}},
+		{"delete", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Delete(ctx, 3, "u1", 0)
+		}},
+		{"restore a subtask", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.Restore(ctx, 4, "u1")
+		}},
+		{"delete list", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.DeleteList(ctx, 3, "u1")
+		}},
+		{"delete the inbox", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.DeleteList(ctx, 1, "u1")
+		}},
+		read("after deleting"),
+		{"rename tag to an existing name", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EditTag(ctx, 1, m.NewTag{Name: "errand"}, "u1")
+		}},
+		{"rename tag", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EditTag(ctx, 1, m.NewTag{Name: "house", Color: "#00ff00"}, "u1")
+		}},
+		{"delete tag", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.DeleteTag(ctx, 2, "u1")
+		}},
+		{"end series", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.EndSeries(ctx, 7, "u1", 0)
+		}},
+		{"skip after the series ended", func(ctx context.Context, db Service) (any, error) {
+			return nil, db.SkipOccurrence(ctx, 7, "u1", 0)
+		}},
+		read("at the end"),
+		{"todos of u2", func(ctx context.Context, db Service) (any, error) {
+			return db.GetAll(ctx, "u2")
+		}},
+	}
+}
+
+// Timestamps set from the clock, which the databases cannot agree on
+var clockTimes = regexp.MustCompile(`"(updatedAt|completedAt|deletedAt)":"[^"]*"`)
+
+/* Describes what a step returned, with clock timestamps left out. */
+func outcome(result any, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	encoded, jsonErr := json.Marshal(result)
+	if jsonErr != nil {
+		return "error encoding result: " + jsonErr.Error()
+	}
+	return clockTimes.ReplaceAllString(string(encoded), `"$1":"set"`)
+}
+
+func TestMemoryMatchesSQLite(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory()
+	sqlite := openSQL(t, filepath.Join(t.TempDir(), "test.db"))
+	// Memory searches as SQLite does without FTS5
+	sqlite.(*service).fts = false
+	for _, db := range []Service{memory, sqlite} {
+		saveUsers(t, db, "u1", "u2")
+	}
+
+	for _, step := range conformanceSteps() {
+		want := outcome(step.call(ctx, sqlite))
+		got := outcome(step.call(ctx, memory))
+		if got != want {
+			t.Errorf("%s:\nmemory returned %s\nsqlite returned %s", step.name, got, want)
+		}
+	}
+}
+
+func TestMemoryCallsAreAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemory()
+	saveUsers(t, db, "u1")
+	due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	id := createTodo(t, db, m.NewTodo{Title: "Buy milk", Recurrence: "FREQ=DAILY", DueAt: &due}, "u1")
+
+	// The next occurrence goes to the todo's list, which no longer exists
+	db.(*memoryService).todos[id].listId = ptr(99)
+	if err := db.SetDone(ctx, int64(id), "u1", true, false, 0); err == nil {
+		t.Fatal("completing an occurrence whose list is gone succeeded")
+	}
+	if todo := getTodo(t, db, id, "u1"); todo.Done || todo.Version != 1 {
+		t.Fatalf("failed completion left the todo changed: %+v", todo)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		id := createTodo(t, db, m.NewTodo{Title: "shared"}, "u1")
+
+		const writers = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, 2*writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := db.Create(ctx, m.NewTodo{Title: fmt.Sprintf("todo %d", i)}, "u1")
+				errs <- err
+				// Every writer edits the version all of them read
+				errs <- db.Edit(ctx, id, m.NewTodo{Title: fmt.Sprintf("edit %d", i)}, "u1", 1)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		edits := 0
+		for err := range errs {
+			if err == nil {
+				edits++
+			} else if !errors.Is(err, ErrVersionConflict) {
+				t.Fatal(err)
+			}
+		}
+		if edits != writers+1 {
+			t.Errorf("%d of %d edits of the same version succeeded, want 1", edits-writers, writers)
+		}
+
+		todos, err := db.GetAll(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions := map[float64]bool{}
+		for _, todo := range todos {
+			positions[todo.Position] = true
+		}
+		if len(todos) != writers+1 || len(positions) != len(todos) {
+			t.Errorf("%d todos with %d distinct positions, want %d", len(todos), len(positions), writers+1)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+	"github.com/teambition/rrule-go"
+)
+
+var (
+	// ErrInvalidRecurrence is returned when a recurrence rule cannot be parsed or the todo has no due date to repeat from.
+	ErrInvalidRecurrence = errors.New("invalid recurrence")
+	// ErrNotRecurring is returned when a series operation targets a todo that is not part of an active series.
+	ErrNotRecurring = errors.New("todo is not part of an active series")
+	// ErrSeriesFinished is returned when skipping the last occurrence of a series.
+	ErrSeriesFinished = errors.New("series has no more occurrences")
+)
+
+// occurrence is the state of a recurring todo needed to schedule the one after it
+type occurrence struct {
+	done     bool
+	dueAt    *time.Time
+	startAt  *time.Time
+	seriesId sql.NullInt64
+	rule     string
+	seriesAt time.Time
+}
+
+/* Moves a recurring todo to its next occurrence without completing it. Takes the Todo id (int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *service) SkipOccurrence(ctx context.Context, id int, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		occ, err := tx.occurrence(ctx, int64(id), userId)
+		if err != nil {
+			return err
+		}
+
+		next, err := tx.nextDue(ctx, occ, userId)
+		if err != nil {
+			return err
+		}
+		if next.IsZero() {
+			return ErrSeriesFinished
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+", dueAt=?, startAt=? WHERE id=? AND userId=? AND (?=0 OR version=?);",
+			formatTime(&next),
+			formatTime(shiftStart(occ, next)),
+			int64(id),
+			userId,
+			version,
+			version)
+		if err != nil {
+			return err
+		}
+
+		return tx.checkVersion(ctx, res, id, userId, version)
+	})
+}
+
+/* Stops a series from generating further occurrences. The todo itself is kept, no longer recurring. Takes the Todo id (int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *service) EndSeries(ctx context.Context, id int, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		occ, err := tx.occurrence(ctx, int64(id), userId)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+" WHERE id=? AND userId=? AND (?=0 OR version=?);", int64(id), userId, version, version)
+		if err != nil {
+			return err
+		}
+		if err := tx.checkVersion(ctx, res, id, userId, version); err != nil {
+			return err
+		}
+
+		_, err = tx.db.Exec(ctx, "UPDATE series SET ended=1 WHERE id=? AND userId=?;", occ.seriesId.Int64, userId)
+		return err
+	})
+}
+
+/* Starts a series for a todo, or replaces the rule of the series it belongs to. */
+func (s *service) setRecurrence(ctx context.Context, id int64, rule string, dueAt *time.Time, userId string) error {
+	rule, err := validateRecurrence(rule, dueAt)
+	if err != nil {
+		return err
+	}
+
+	var seriesId sql.NullInt64
+	if err := s.db.QueryRow(ctx, "SELECT seriesId FROM todos WHERE id=? AND userId=?;", id, userId).Scan(&seriesId); err != nil {
+		return err
+	}
+
+	if seriesId.Valid {
+		_, err := s.db.Exec(ctx, "UPDATE series SET rule=?, startAt=?, ended=0 WHERE id=? AND userId=?;", rule, formatTime(dueAt), seriesId.Int64, userId)
+		return err
+	}
+
+	newId, err := s.db.insert(ctx, "INSERT INTO series (rule, startAt, userId) VALUES(?,?,?);", rule, formatTime(dueAt), userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, "UPDATE todos SET seriesId=? WHERE id=? AND userId=?;", newId, id, userId)
+	return err
+}
+
+/* Normalizes a recurrence rule and checks that it parses and has a due date to repeat from. */
+func validateRecurrence(rule string, dueAt *time.Time) (string, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	if dueAt == nil {
+		return "", fmt.Errorf("%w: a recurring todo needs a due date", ErrInvalidRecurrence)
+	}
+	if _, err := rrule.StrToROption(rule); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+
+	return rule, nil
+}
+
+/* Creates the occurrence following a completed recurring todo, copying its details with the dates advanced. Does nothing if the todo is not done, its series has ended or the next occurrence already exists. */
+func (s *service) createNextOccurrence(ctx context.Context, id int64, userId string) error {
+	occ, err := s.occurrence(ctx, id, userId)
+	if errors.Is(err, ErrNotRecurring) || (err == nil && !occ.done) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Completing, reopening and completing again must not schedule twice
+	var pending int
+	if err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM todos WHERE seriesId=? AND userId=? AND dueAt>? AND deletedAt IS NULL;",
+		occ.seriesId.Int64, userId, formatTime(occ.dueAt)).Scan(&pending); err != nil {
+		return err
+	}
+	if pending > 0 {
+		return nil
+	}
+
+	next, err := s.nextDue(ctx, occ, userId)
+	if err != nil || next.IsZero() {
+		return err
+	}
+
+	var todo m.NewTodo
+	var listId, parentId sql.NullInt64
+	if err := s.db.QueryRow(ctx, "SELECT title, description, priority, listId, parentId FROM todos WHERE id=?;", id).Scan(
+		&todo.Title, &todo.Description, &todo.Priority, &listId, &parentId); err != nil {
+		return err
+	}
+	if listId.Valid {
+		todo.ListId = intPtr(listId.Int64)
+	}
+	if parentId.Valid {
+		todo.ParentId = intPtr(parentId.Int64)
+	}
+	if todo.Tags, err = s.tagNames(ctx, id); err != nil {
+		return err
+	}
+	todo.DueAt = &next
+	todo.StartAt = shiftStart(occ, next)
+
+	newId, err := s.Create(ctx, todo, userId)
+	if err != nil {
+		log.Println("error creating next occurrence of recurring todo")
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, "UPDATE todos SET seriesId=? WHERE id=?;", occ.seriesId.Int64, int64(newId))
+	return err
+}
+
+/* Loads the recurrence state of a todo, returning ErrNotRecurring if it is not part of an active series. */
+func (s *service) occurrence(ctx context.Context, id int64, userId string) (occurrence, error) {
+	var occ occurrence
+	var rule sql.NullString
+	var seriesAt *time.Time
+	var ended sql.NullBool
+	err := s.db.QueryRow(ctx, `SELECT todos.done, todos.dueAt, todos.startAt, todos.seriesId, series.rule, series.startAt, series.ended
+		FROM todos LEFT JOIN series ON series.id=todos.seriesId
+		WHERE todos.id=? AND todos.userId=? AND todos.deletedAt IS NULL;`, id, userId).Scan(
+		&occ.done, &occ.dueAt, &occ.startAt, &occ.seriesId, &rule, &seriesAt, &ended)
+	if err == sql.ErrNoRows {
+		return occ, ErrNotFound
+	} else if err != nil {
+		return occ, err
+	}
+
+	if !occ.seriesId.Valid || !rule.Valid || ended.Bool || occ.dueAt == nil {
+		return occ, ErrNotRecurring
+	}
+
+	occ.rule = rule.String
+	occ.seriesAt = *seriesAt
+	return occ, nil
+}
+
+/* Returns the first occurrence of the series after the due date of occ, or the zero time when the series is over. Rules are evaluated in the user's time zone so that, for example, BYDAY matches the user's calendar. */
+func (s *service) nextDue(ctx context.Context, occ occurrence, userId string) (time.Time, error) {
+	timeZone, _ := s.GetTimeZone(ctx, userId)
+	return occurrenceAfter(occ, timeZone)
+}
+
+/* Returns the first occurrence of the series after the due date of occ, evaluating the rule in the given time zone, or in UTC if it is not valid. */
+func occurrenceAfter(occ occurrence, timeZone string) (time.Time, error) {
+	loc := time.UTC
+	if l, err := time.LoadLocation(timeZone); err == nil {
+		loc = l
+	}
+
+	opt, err := rrule.StrToROption(occ.rule)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	opt.Dtstart = occ.seriesAt.In(loc)
+
+	rule, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+
+	return rule.After(occ.dueAt.In(loc), false), nil
+}
+
+/* Keeps the gap between start and due date when an occurrence moves to a new due date. */
+func shiftStart(occ occurrence, next time.Time) *time.Time {
+	if occ.startAt == nil {
+		return nil
+	}
+
+	start := next.Add(occ.startAt.Sub(*occ.dueAt))
+	return &start
+}
+
+func intPtr(v int64) *int {
+	i := int(v)
+	return &i
+}
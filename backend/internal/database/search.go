@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/raziel-aleman/go-todo-app/internal/migrations"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// ErrInvalidQuery is returned when a search query is not valid FTS5 query syntax.
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// errNoFTS5 is returned when the database has a search index that this
+// build of SQLite cannot update.
+var errNoFTS5 = errors.New("the database has a full text search index but SQLite was built without FTS5, build with -tags sqlite_fts5")
+
+// Delimiters the databases put around matched terms, replaced by marks once the
+// text has been HTML escaped. A todo containing them gets a stray mark at worst,
+// never markup of its own.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+var highlighter = strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>")
+
+/* HTML escapes a highlighted title or body and marks its matched terms with <mark></mark>, so clients can render highlights as HTML. */
+func highlight(text string) string {
+	return highlighter.Replace(html.EscapeString(text))
+}
+
+/* Reports whether the FTS5 search index created by the search index migration is available. Without it search falls back to substring matching. */
+func searchIndexReady(db *sql.DB) (bool, error) {
+	var indexed int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='todos_fts';").Scan(&indexed); err != nil {
+		return false, fmt.Errorf("checking for search index: %w", err)
+	}
+	fts5, err := migrations.HasFTS5(db)
+	if err != nil {
+		return false, fmt.Errorf("checking for FTS5: %w", err)
+	}
+
+	switch {
+	case indexed == 0 && fts5:
+		log.Println("the search index migration is not applied, search will use substring matching")
+	case indexed == 0:
+		log.Println("SQLite was built without FTS5 (build with -tags sqlite_fts5), search will use substring matching")
+	case !fts5:
+		// Its triggers would make every write to todos fail
+		return false, errNoFTS5
+	}
+
+	return indexed > 0, nil
+}
+
+/* Searches the titles and bodies of a user's todos. Supports FTS5 query syntax on SQLite: "phrases", prefix*, AND, OR and NOT, and web search syntax on Postgres. Takes the userId (string), the query (string) and a limit (int) and returns the best matches first ([]m.SearchResult) and an error. */
+func (s *service) Search(ctx context.Context, userId string, query string, limit int) ([]m.SearchResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if !s.fts {
+		return s.searchSubstring(ctx, userId, query, limit)
+	}
+	if s.db.dialect.name == postgresDialect.name {
+		return s.searchPostgres(ctx, userId, query, limit)
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT `+todoColumns+`, hits.titleSnippet, hits.bodySnippet, hits.rank FROM todos JOIN (
+			SELECT rowid,
+				snippet(todos_fts, 0, ?, ?, '…', 16) AS titleSnippet,
+				snippet(todos_fts, 1, ?, ?, '…', 16) AS bodySnippet,
+				bm25(todos_fts, 2.0, 1.0) AS rank
+			FROM todos_fts WHERE todos_fts MATCH ?
+		) AS hits ON hits.rowid=todos.id
+		WHERE todos.userId=? AND todos.deletedAt IS NULL
+		ORDER BY hits.rank LIMIT ?;`, matchStart, matchEnd, matchStart, matchEnd, query, userId, limit)
+	if err != nil {
+		log.Println("error searching todos")
+		return nil, searchError(err)
+	}
+
+	results, err := s.scanSearchResults(ctx, rows, userId)
+	return results, searchError(err)
+}
+
+/* Maps FTS5 query syntax errors, which SQLite may report when the query runs or while stepping through rows, to ErrInvalidQuery. */
+func searchError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "fts5:") {
+		return fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+	return err
+}
+
+// Document searched on Postgres, matching the todos_search index
+const searchDocument = "to_tsvector('simple', title || ' ' || description)"
+
+// Options of ts_headline marking matched terms as FTS5 snippets do
+const headlineOptions = "StartSel=" + matchStart + ", StopSel=" + matchEnd
+
+/* Searches titles and bodies with Postgres full text search. Queries use web search syntax: "phrases", or, and -excluded words. Ranks are negated so that lower ranks match better, as with FTS5. */
+func (s *service) searchPostgres(ctx context.Context, userId string, query string, limit int) ([]m.SearchResult, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+todoColumns+`,
+			ts_headline('simple', title, q, ?),
+			ts_headline('simple', description, q, ?),
+			-ts_rank(`+searchDocument+`, q) AS rank
+		FROM todos, websearch_to_tsquery('simple', ?) AS q
+		WHERE userId=? AND deletedAt IS NULL AND `+searchDocument+` @@ q
+		ORDER BY rank, id LIMIT ?;`, headlineOptions+", HighlightAll=true", headlineOptions+", MaxWords=16, MinWords=8", query, userId, limit)
+	if err != nil {
+		log.Println("error searching todos")
+		return nil, err
+	}
+
+	return s.scanSearchResults(ctx, rows, userId)
+}
+
+/* Searches titles and bodies for the query as a plain substring when the FTS5 index is not available. */
+func (s *service) searchSubstring(ctx context.Context, userId string, query string, limit int) ([]m.SearchResult, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := s.db.Query(ctx, `SELECT `+todoColumns+`, title, description, 0 FROM todos
+		WHERE userId=? AND deletedAt IS NULL AND (LOWER(title) LIKE LOWER(?) ESCAPE '\' OR LOWER(description) LIKE LOWER(?) ESCAPE '\')
+		ORDER BY position, id LIMIT ?;`, userId, pattern, pattern, limit)
+	if err != nil {
+		log.Println("error searching todos")
+		return nil, err
+	}
+
+	return s.scanSearchResults(ctx, rows, userId)
+}
+
+/* Scans search results and fills in their tags. */
+func (s *service) scanSearchResults(ctx context.Context, rows *sql.Rows, userId string) ([]m.SearchResult, error) {
+	defer rows.Close()
+
+	results := []m.SearchResult{}
+	for rows.Next() {
+		result := m.SearchResult{}
+		todo, err := scanTodo(rows, &result.Highlights.Title, &result.Highlights.Body, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.Todo = todo
+		result.Highlights.Title = highlight(result.Highlights.Title)
+		result.Highlights.Body = highlight(result.Highlights.Body)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	todos := make([]m.Todo, len(results))
+	for i := range results {
+		todos[i] = results[i].Todo
+	}
+	if err := s.attachTags(ctx, todos, userId); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Tags = todos[i].Tags
+	}
+
+	return results, nil
+}
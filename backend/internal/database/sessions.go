@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Columns of a session, in the order scanSession reads them
+const sessionColumns = "id, tokenHash, userId, data, ip, userAgent, device, createdAt, lastSeenAt, expiresAt"
+
+/* Creates a new Session, ending the oldest sessions of its user beyond maxSessions unless it is zero. Takes a Session struct with the token hash, user, data, client and expiry set and maxSessions (int) and returns an id (int) and an error. */
+func (s *service) CreateSession(ctx context.Context, session m.Session, maxSessions int) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var id int
+	err := s.withTx(ctx, func(tx *service) error {
+		var err error
+		id, err = tx.db.insert(ctx, "INSERT INTO sessions (tokenHash, userId, data, ip, userAgent, device, expiresAt) VALUES(?,?,?,?,?,?,?);",
+			session.TokenHash,
+			session.UserId,
+			session.Data,
+			session.IP,
+			session.UserAgent,
+			session.Device,
+			formatTime(&session.ExpiresAt))
+		if err != nil {
+			log.Println("could not insert session to database")
+			return err
+		}
+
+		if maxSessions <= 0 {
+			return nil
+		}
+
+		_, err = tx.db.Exec(ctx, `DELETE FROM sessions WHERE userId=? AND id NOT IN (
+			SELECT id FROM sessions WHERE userId=? AND expiresAt > CURRENT_TIMESTAMP ORDER BY createdAt DESC, id DESC LIMIT ?
+		);`, session.UserId, session.UserId, maxSessions)
+		if err != nil {
+			log.Println("could not end the oldest sessions of the user")
+		}
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+/* Retrieves an unexpired Session. Takes the hash of its token (string) and returns a Session and an error. */
+func (s *service) GetSession(ctx context.Context, tokenHash string) (m.Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE tokenHash=? AND expiresAt > CURRENT_TIMESTAMP;", tokenHash)
+	if err != nil {
+		return m.Session{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return m.Session{}, err
+		}
+		return m.Session{}, ErrNotFound
+	}
+
+	return scanSession(rows)
+}
+
+/* Saves the data of a Session and records it as seen now from its address and browser. Takes a Session struct and returns an error. */
+func (s *service) UpdateSession(ctx context.Context, session m.Session) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, `UPDATE sessions SET data=?, ip=?, userAgent=?, lastSeenAt=CURRENT_TIMESTAMP
+		WHERE id=? AND userId=? AND expiresAt > CURRENT_TIMESTAMP;`,
+		session.Data,
+		session.IP,
+		session.UserAgent,
+		session.ID,
+		session.UserId)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+/* Retrieves the unexpired sessions of a user, most recently seen first. Takes the userId (string) and returns an array of Sessions ([]m.Session) and an error. */
+func (s *service) GetSessions(ctx context.Context, userId string) ([]m.Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE userId=? AND expiresAt > CURRENT_TIMESTAMP ORDER BY lastSeenAt DESC, id DESC;", userId)
+	if err != nil {
+		log.Println("error selecting sessions from database")
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []m.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			log.Println("error scanning sessions from select")
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+/* Ends a Session. Takes the Session id (int) and userId (string) and returns an error. */
+func (s *service) DeleteSession(ctx context.Context, id int, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE id=? AND userId=?;", id, userId)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+/* Ends every session of a user but one. Takes the id of the Session to keep (int) and userId (string) and returns the number of sessions ended (int64) and an error. */
+func (s *service) DeleteOtherSessions(ctx context.Context, keepId int, userId string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE userId=? AND id<>? AND expiresAt > CURRENT_TIMESTAMP;", userId, keepId)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+/* Deletes expired sessions. Returns the number of sessions deleted (int64) and an error. */
+func (s *service) PurgeSessions(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE expiresAt <= CURRENT_TIMESTAMP;")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+/* Reads a session selected with sessionColumns from a row. */
+func scanSession(rows *sql.Rows) (m.Session, error) {
+	var session m.Session
+	err := rows.Scan(
+		&session.ID,
+		&session.TokenHash,
+		&session.UserId,
+		&session.Data,
+		&session.IP,
+		&session.UserAgent,
+		&session.Device,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt)
+	return session, err
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// ErrCycle is returned when a todo would become a subtask of itself or of one of its own subtasks.
+var ErrCycle = errors.New("a todo cannot be a subtask of itself or its subtasks")
+
+/* Retrieves the direct subtasks of a todo. Takes the Todo id (int) and userId (string) and returns an array of Todos ([]m.Todo) and an error. */
+func (s *service) GetChildren(ctx context.Context, id int, userId string) ([]m.Todo, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := s.todoListId(ctx, id, userId); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, "SELECT "+todoColumns+" FROM todos WHERE parentId=? AND userId=? AND deletedAt IS NULL ORDER BY position, id;", int64(id), userId)
+	if err != nil {
+		log.Println("error selecting subtasks from database")
+		return nil, err
+	}
+
+	todos, err := scanTodos(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, s.attachTags(ctx, todos, userId)
+}
+
+/* Makes a todo a subtask of another, or a top level todo when parentId is nil. Takes the Todo id (int), parent id (*int), userId (string) and the version (int) the change was based on, or 0 for any version, and returns an error. */
+func (s *service) SetParent(ctx context.Context, id int, parentId *int, userId string, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.withTx(ctx, func(tx *service) error {
+		if parentId != nil {
+			if _, err := tx.todoListId(ctx, *parentId, userId); err != nil {
+				return err
+			}
+
+			// Walk up from the new parent; finding the todo itself means the move would create a cycle
+			var found int
+			err := tx.db.QueryRow(ctx, `WITH RECURSIVE ancestors(id) AS (
+				SELECT CAST(? AS INTEGER)
+				UNION
+				SELECT todos.parentId FROM todos JOIN ancestors ON todos.id=ancestors.id WHERE todos.parentId IS NOT NULL
+			) SELECT COUNT(*) FROM ancestors WHERE id=?;`, int64(*parentId), int64(id)).Scan(&found)
+			if err != nil {
+				return err
+			}
+			if found > 0 {
+				return ErrCycle
+			}
+		}
+
+		res, err := tx.db.Exec(ctx, "UPDATE todos SET "+bumpVersion+", parentId=? WHERE id=? AND userId=? AND deletedAt IS NULL AND (?=0 OR version=?);", parentId, int64(id), userId, version, version)
+		if err != nil {
+			return err
+		}
+
+		return tx.checkVersion(ctx, res, id, userId, version)
+	})
+}
+
+/* Marks every subtask below a completed todo as done, at any depth. Subtasks in the trash are left as they are. */
+func (s *service) completeDescendants(ctx context.Context, id int64, userId string) error {
+	_, err := s.db.Exec(ctx, `WITH RECURSIVE descendants(id) AS (
+		SELECT id FROM todos WHERE parentId=? AND userId=? AND deletedAt IS NULL
+		UNION
+		SELECT todos.id FROM todos JOIN descendants ON todos.parentId=descendants.id WHERE todos.deletedAt IS NULL
+	) UPDATE todos SET `+bumpVersion+`, completedAt=CASE WHEN done=1 THEN completedAt ELSE CURRENT_TIMESTAMP END, done=1
+		WHERE id IN (SELECT id FROM descendants) AND userId=? AND done=0;`, id, userId, userId)
+	return err
+}
+
+/* Returns the list of a todo, or ErrNotFound if the user does not own it. */
+func (s *service) todoListId(ctx context.Context, parentId int, userId string) (int, error) {
+	var listId sql.NullInt64
+	if err := s.db.QueryRow(ctx, "SELECT listId FROM todos WHERE id=? AND userId=? AND deletedAt IS NULL;", int64(parentId), userId).Scan(&listId); err != nil {
+		if err == sql.ErrNoRows {
+			return -1, ErrNotFound
+		}
+		return -1, err
+	}
+
+	if !listId.Valid {
+		return s.inboxId(ctx, userId)
+	}
+
+	return int(listId.Int64), nil
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// ErrTagExists is returned when a user already has a tag with the same name.
+var ErrTagExists = errors.New("a tag with this name already exists")
+
+/* Retrieves all tags of a user ordered by name. Takes the userId (string) and returns an array of Tags ([]m.Tag) and an error. */
+func (s *service) GetTags(ctx context.Context, userId string) ([]m.Tag, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.Query(ctx, "SELECT id, name, color FROM tags WHERE userId=? ORDER BY name;", userId)
+	if err != nil {
+		log.Println("error selecting tags from database")
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []m.Tag{}
+	for rows.Next() {
+		tag := m.Tag{}
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Color); err != nil {
+			log.Println("error scanning tags from select")
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+/* Creates new Tag. Takes a NewTag struct and userId (string) and returns an id (int) and an error. */
+func (s *service) CreateTag(ctx context.Context, tag m.NewTag, userId string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	id, err := s.db.insert(ctx, "INSERT INTO tags (name, color, userId) VALUES(?,?,?);", tag.Name, tag.Color, userId)
+	if err != nil {
+		return -1, s.tagError(err)
+	}
+
+	return id, nil
+}
+
+/* Renames or recolours a Tag. Takes the Tag id (int), a NewTag struct and userId (string) and returns an error. */
+func (s *service) EditTag(ctx context.Context, id int, tag m.NewTag, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, "UPDATE tags SET name=?, color=? WHERE id=? AND userId=?;", tag.Name, tag.Color, int64(id), userId)
+	if err != nil {
+		return s.tagError(err)
+	}
+
+	return checkAffected(res)
+}
+
+/* Deletes a Tag. Todos keep existing and lose the tag through the todo_tags cascade. Takes the Tag id (int) and userId (string) and returns an error. */
+func (s *service) DeleteTag(ctx context.Context, id int, userId string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.Exec(ctx, "DELETE FROM tags WHERE id=? AND userId=?;", int64(id), userId)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+/* Replaces the tags of a todo with the given tag names, creating tags that do not exist yet. */
+func (s *service) setTodoTags(ctx context.Context, todoId int64, names []string, userId string) error {
+	if _, err := s.db.Exec(ctx, "DELETE FROM todo_tags WHERE todoId=?;", todoId); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if _, err := s.db.Exec(ctx, "INSERT INTO tags (name, userId) VALUES(?,?) ON CONFLICT (userId, name) DO NOTHING;", name, userId); err != nil {
+			return err
+		}
+
+		if _, err := s.db.Exec(ctx, `INSERT INTO todo_tags (todoId, tagId)
+			SELECT CAST(? AS INTEGER), id FROM tags WHERE userId=? AND name=?
+			ON CONFLICT (todoId, tagId) DO NOTHING;`, todoId, userId, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Most todos whose tags are loaded by one query, keeping well below the
+// number of parameters a statement may have
+const tagBatchSize = 500
+
+/* Fills in the tag names of each todo. */
+func (s *service) attachTags(ctx context.Context, todos []m.Todo, userId string) error {
+	byId := make(map[int]*m.Todo, len(todos))
+	for i := range todos {
+		todos[i].Tags = []string{}
+		byId[todos[i].ID] = &todos[i]
+	}
+
+	for start := 0; start < len(todos); start += tagBatchSize {
+		batch := todos[start:min(start+tagBatchSize, len(todos))]
+		if err := s.loadTags(ctx, batch, byId, userId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* Appends the tag names of a batch of todos to the todos in byId. */
+func (s *service) loadTags(ctx context.Context, batch []m.Todo, byId map[int]*m.Todo, userId string) error {
+	args := []any{userId}
+	for _, todo := range batch {
+		args = append(args, int64(todo.ID))
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+	rows, err := s.db.Query(ctx, `SELECT todo_tags.todoId, tags.name FROM todo_tags
+		JOIN tags ON tags.id=todo_tags.tagId
+		WHERE tags.userId=? AND todo_tags.todoId IN (`+placeholders+`) ORDER BY tags.name;`, args...)
+	if err != nil {
+		log.Println("error selecting todo tags from database")
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoId int
+		var name string
+		if err := rows.Scan(&todoId, &name); err != nil {
+			return err
+		}
+		if todo, ok := byId[todoId]; ok {
+			todo.Tags = append(todo.Tags, name)
+		}
+	}
+
+	return rows.Err()
+}
+
+/* Maps a unique constraint violation on the tag name to ErrTagExists. */
+func (s *service) tagError(err error) error {
+	if s.db.dialect.isUniqueViolation(err) {
+		return ErrTagExists
+	}
+	return err
+}
+
+/* Retrieves the tag names of a todo. */
+func (s *service) tagNames(ctx context.Context, todoId int64) ([]string, error) {
+	rows, err := s.db.Query(ctx, "SELECT tags.name FROM todo_tags JOIN tags ON tags.id=todo_tags.tagId WHERE todo_tags.todoId=? ORDER BY tags.name;", todoId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
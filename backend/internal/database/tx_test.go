@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+/* Opens an empty SQLite database, returning the service so that tests can reach its connection pool. */
+func openSQLite(t *testing.T) *service {
+	t.Helper()
+	return openSQL(t, filepath.Join(t.TempDir(), "test.db")).(*service)
+}
+
+/* Makes statements fail with "injected failure" until the test ends. The failure is raised by a trigger that runs before event on table when condition holds. */
+func injectFailure(t *testing.T, s *service, event string, table string, condition string) {
+	t.Helper()
+
+	_, err := s.pool.Exec(fmt.Sprintf(`CREATE TRIGGER inject_failure BEFORE %s ON %s WHEN %s BEGIN
+		SELECT RAISE(ABORT, 'injected failure');
+	END;`, event, table, condition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.pool.Exec("DROP TRIGGER IF EXISTS inject_failure;") })
+}
+
+/* Checks that err is the injected failure. */
+func checkInjected(t *testing.T, err error) {
+	t.Helper()
+
+	if err == nil || !strings.Contains(err.Error(), "injected failure") {
+		t.Fatalf("error = %v, want the injected failure", err)
+	}
+}
+
+func TestFailedMoveRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+	saveUsers(t, s, "u1")
+	first := createTodo(t, s, m.NewTodo{Title: "first"}, "u1")
+	second := createTodo(t, s, m.NewTodo{Title: "second"}, "u1")
+	third := createTodo(t, s, m.NewTodo{Title: "third"}, "u1")
+
+	// Leave no room between the first two todos, so moving between them renumbers every todo first
+	if _, err := s.pool.Exec("UPDATE todos SET position=1.0000000001 WHERE id=?;", second); err != nil {
+		t.Fatal(err)
+	}
+	injectFailure(t, s, "UPDATE OF position", "todos", "NEW.position <> CAST(NEW.position AS INTEGER)")
+
+	checkInjected(t, s.Move(ctx, third, m.MoveTodo{After: &first, Before: &second}, "u1", 0))
+
+	if todo := getTodo(t, s, second, "u1"); todo.Position != 1.0000000001 {
+		t.Errorf("failed move renumbered the todos, second is at %v", todo.Position)
+	}
+	if todo := getTodo(t, s, third, "u1"); todo.Position != 3 || todo.Version != 1 {
+		t.Errorf("failed move changed the moved todo: %+v", todo)
+	}
+}
+
+func TestFailedRecurrenceCompletionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+	saveUsers(t, s, "u1")
+	due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	id := createTodo(t, s, m.NewTodo{Title: "Buy milk", Recurrence: "FREQ=DAILY", DueAt: &due}, "u1")
+
+	// Completing the occurrence succeeds, creating the next one fails
+	injectFailure(t, s, "INSERT", "todos", "1")
+
+	checkInjected(t, s.SetDone(ctx, int64(id), "u1", true, false, 0))
+
+	if todo := getTodo(t, s, id, "u1"); todo.Done || todo.CompletedAt != nil || todo.Version != 1 {
+		t.Errorf("failed completion left the occurrence changed: %+v", todo)
+	}
+}
+
+func TestFailedSubtaskCompletionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+	saveUsers(t, s, "u1")
+	parent := createTodo(t, s, m.NewTodo{Title: "parent"}, "u1")
+	child := createTodo(t, s, m.NewTodo{Title: "child", ParentId: &parent}, "u1")
+
+	injectFailure(t, s, "UPDATE OF done", "todos", fmt.Sprintf("NEW.id=%d", child))
+
+	checkInjected(t, s.SetDone(ctx, int64(parent), "u1", true, true, 0))
+
+	if todo := getTodo(t, s, parent, "u1"); todo.Done || todo.Version != 1 {
+		t.Errorf("failed completion left the parent changed: %+v", todo)
+	}
+}
+
+func TestFailedSetParentRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+	saveUsers(t, s, "u1")
+	parent := createTodo(t, s, m.NewTodo{Title: "parent"}, "u1")
+	id := createTodo(t, s, m.NewTodo{Title: "todo"}, "u1")
+
+	injectFailure(t, s, "UPDATE OF parentId", "todos", "1")
+
+	// Moving a todo into its new list and under its new parent is one unit of work
+	err := s.WithTx(ctx, func(tx Service) error {
+		if err := tx.Edit(ctx, id, m.NewTodo{Title: "subtask"}, "u1", 0); err != nil {
+			return err
+		}
+		return tx.SetParent(ctx, id, &parent, "u1", 0)
+	})
+	checkInjected(t, err)
+
+	if todo := getTodo(t, s, id, "u1"); todo.Title != "todo" || todo.ParentId != nil || todo.Version != 1 {
+		t.Errorf("failed transaction left the todo changed: %+v", todo)
+	}
+}
+
+func TestFailedSaveUserRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+
+	// The user is inserted, their inbox is not
+	injectFailure(t, s, "INSERT", "lists", "1")
+
+	checkInjected(t, s.SaveUser(ctx, goth.User{UserID: "u1", Name: "u1"}))
+
+	var users int
+	if err := s.pool.QueryRow("SELECT COUNT(*) FROM users;").Scan(&users); err != nil || users != 0 {
+		t.Fatalf("%d users after the failed save, %v", users, err)
+	}
+}
+
+func TestFailedSessionEvictionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t)
+	saveUsers(t, s, "u1")
+	expires := time.Now().Add(time.Hour).UTC()
+	if _, err := s.CreateSession(ctx, m.Session{TokenHash: "old", UserId: "u1", ExpiresAt: expires}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new session is inserted, ending the old one fails
+	injectFailure(t, s, "DELETE", "sessions", "1")
+
+	_, err := s.CreateSession(ctx, m.Session{TokenHash: "new", UserId: "u1", ExpiresAt: expires}, 1)
+	checkInjected(t, err)
+
+	if _, err := s.GetSession(ctx, "new"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("getting the session of the failed login: %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetSession(ctx, "old"); err != nil {
+		t.Errorf("getting the session kept by the failed login: %v", err)
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Service) {
+		ctx := context.Background()
+		saveUsers(t, db, "u1")
+		due := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+		recurring := createTodo(t, db, m.NewTodo{Title: "Buy milk", Recurrence: "FREQ=DAILY", DueAt: &due}, "u1")
+		parent := createTodo(t, db, m.NewTodo{Title: "parent"}, "u1")
+		child := createTodo(t, db, m.NewTodo{Title: "child", ParentId: &parent}, "u1")
+
+		// Every step but the last succeeds
+		err := db.WithTx(ctx, func(tx Service) error {
+			if err := tx.Move(ctx, child, m.MoveTodo{Before: &recurring}, "u1", 0); err != nil {
+				return err
+			}
+			if err := tx.SetDone(ctx, int64(recurring), "u1", true, false, 0); err != nil {
+				return err
+			}
+			if err := tx.SetParent(ctx, child, nil, "u1", 0); err != nil {
+				return err
+			}
+			return tx.SetParent(ctx, parent, &parent, "u1", 0)
+		})
+		if !errors.Is(err, ErrCycle) {
+			t.Fatalf("transaction: %v, want ErrCycle", err)
+		}
+
+		todos, err := db.GetAll(ctx, "u1")
+		if err != nil || !slices.Equal(todoIds(todos), []int{recurring, parent, child}) {
+			t.Fatalf("todos after rollback = %v, %v", todoIds(todos), err)
+		}
+		for _, todo := range todos {
+			if todo.Version != 1 || todo.Done {
+				t.Errorf("todo %d changed by the rolled back transaction: %+v", todo.ID, todo)
+			}
+		}
+		if todo := getTodo(t, db, child, "u1"); todo.ParentId == nil || *todo.ParentId != parent {
+			t.Errorf("child was detached by the rolled back transaction: %+v", todo)
+		}
+
+		// The same steps commit together when they all succeed
+		err = db.WithTx(ctx, func(tx Service) error {
+			if err := tx.SetDone(ctx, int64(recurring), "u1", true, false, 0); err != nil {
+				return err
+			}
+			return tx.SetParent(ctx, child, nil, "u1", 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if todos, err = db.GetAll(ctx, "u1"); err != nil || len(todos) != 4 {
+			t.Fatalf("todos after commit = %v, %v", todoIds(todos), err)
+		}
+	})
+}
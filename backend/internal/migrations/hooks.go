@@ -0,0 +1,44 @@
+package migrations
+
+import "database/sql"
+
+// hook adds Go code to a migration, for changes a script cannot express
+// because they depend on what is already in the database. Hooks are not part
+// of the checksum.
+type hook struct {
+	// Runs in the migration's transaction before its up script
+	before func(tx *sql.Tx, dialect string) error
+
+	// Reports whether the migration can run. Until it can, it stays pending
+	// and newer migrations are applied without it, so they must not depend
+	// on it
+	when func(q queryRower) (bool, error)
+}
+
+// Hooks of each dialect by migration version. Databases created before
+// migrations existed are adopted by 0001_init as they are, with the columns
+// their tables had back then, so the missing columns are added before
+// 0002_indexes needs them. This cannot run as part of 0001_init because
+// databases that failed to start on 0002_indexes already recorded it.
+// 0005_search_index needs SQLite built with FTS5, without it the migration
+// stays pending and search falls back to substring matching.
+var hooks = map[string]map[int]hook{
+	"sqlite": {
+		2: {before: upgradeLegacySchema},
+		5: {when: HasFTS5},
+	},
+	"postgres": {2: {before: upgradeLegacySchema}},
+}
+
+// queryRower is implemented by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// HasFTS5 reports whether SQLite was built with the FTS5 extension, which the
+// mattn/go-sqlite3 driver only includes with the sqlite_fts5 build tag.
+func HasFTS5(db queryRower) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5');").Scan(&enabled)
+	return enabled, err
+}
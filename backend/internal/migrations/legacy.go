@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// legacyColumn is a column that was added to a table after its first release.
+type legacyColumn struct {
+	table    string
+	name     string
+	sqlite   string
+	postgres string
+}
+
+// Columns added to the users and todos tables after the first release, in
+// the order 0001_init declares them. Foreign keys added by ALTER TABLE must
+// default to NULL on SQLite.
+var legacyColumns = []legacyColumn{
+	{"users", "timeZone", "TEXT NOT NULL DEFAULT 'UTC'", "TEXT NOT NULL DEFAULT 'UTC'"},
+	{"todos", "priority", "INTEGER NOT NULL DEFAULT 0", "INTEGER NOT NULL DEFAULT 0"},
+	{"todos", "position", "REAL NOT NULL DEFAULT 0", "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{"todos", "listId", "INTEGER REFERENCES lists (id) ON DELETE CASCADE", "INTEGER REFERENCES lists (id) ON DELETE CASCADE"},
+	{"todos", "parentId", "INTEGER REFERENCES todos (id) ON DELETE CASCADE", "INTEGER REFERENCES todos (id) ON DELETE CASCADE"},
+	{"todos", "seriesId", "INTEGER REFERENCES series (id) ON DELETE SET NULL", "INTEGER REFERENCES series (id) ON DELETE SET NULL"},
+	{"todos", "dueAt", "DATE", "TIMESTAMP"},
+	{"todos", "completedAt", "DATE", "TIMESTAMP"},
+	{"todos", "startAt", "DATE", "TIMESTAMP"},
+	{"todos", "deletedAt", "DATE", "TIMESTAMP"},
+	{"todos", "version", "INTEGER NOT NULL DEFAULT 1", "INTEGER NOT NULL DEFAULT 1"},
+	{"todos", "updatedAt", "DATE", "TIMESTAMP"},
+}
+
+/* Adds the columns a legacy database is missing. Todos that had no position keep the order they were created in. */
+func upgradeLegacySchema(tx *sql.Tx, dialect string) error {
+	existing := map[string]map[string]bool{}
+	for _, column := range legacyColumns {
+		if existing[column.table] != nil {
+			continue
+		}
+		names, err := columnNames(tx, dialect, column.table)
+		if err != nil {
+			return err
+		}
+		existing[column.table] = names
+	}
+
+	for _, column := range legacyColumns {
+		if existing[column.table][strings.ToLower(column.name)] {
+			continue
+		}
+
+		definition := column.sqlite
+		if dialect == "postgres" {
+			definition = column.postgres
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", column.table, column.name, definition)); err != nil {
+			return fmt.Errorf("adding %s.%s: %w", column.table, column.name, err)
+		}
+
+		if column.table == "todos" && column.name == "position" {
+			if _, err := tx.Exec("UPDATE todos SET position=id;"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/* Returns the lower case names of the columns of a table. */
+func columnNames(tx *sql.Tx, dialect string, table string) (map[string]bool, error) {
+	query := "SELECT name FROM pragma_table_info(?);"
+	if dialect == "postgres" {
+		query = "SELECT column_name FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=$1;"
+	}
+
+	rows, err := tx.Query(query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(name)] = true
+	}
+
+	return names, rows.Err()
+}
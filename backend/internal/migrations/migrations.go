@@ -0,0 +1,373 @@
+// Package migrations versions the database schema. Migrations are embedded SQL
+// scripts named <version>_<name>.up.sql and <version>_<name>.down.sql, applied
+// in order of version and recorded in the schema_migrations table together
+// with a checksum of the script that was run. Each SQL dialect has its own
+// directory of scripts.
+//
+// Concurrent runs, like two servers starting at once, do not step on each
+// other: a run is one transaction that first takes a lock, an advisory lock
+// on Postgres and the write lock on SQLite. SQLite only takes the write lock
+// at the start of a transaction when the database is opened with
+// _txlock=immediate, as the database package does.
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var scripts embed.FS
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration's script was
+	// changed afterwards. Applied migrations must never be edited, add a new one.
+	ErrChecksumMismatch = errors.New("migration was changed after it was applied")
+	// ErrUnknownVersion is returned when the database has a migration applied
+	// that this build does not know, or when migrating to a version that does
+	// not exist.
+	ErrUnknownVersion = errors.New("unknown migration version")
+	// ErrConditionNotMet is returned when reverting a migration that only
+	// applies under a condition, such as SQLite having FTS5, that does not hold.
+	ErrConditionNotMet = errors.New("the condition of the migration does not hold")
+)
+
+// Migration is one step of the schema, with the scripts to apply and revert it.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Set when the applied script differs from the embedded one
+	Modified bool
+}
+
+// Migrator applies and reverts the embedded migrations on a database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []Migration
+	hooks      map[int]hook
+}
+
+var scriptName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// New returns a Migrator for the migrations of a dialect, sqlite or postgres.
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := load(scripts, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations, hooks: hooks[dialect]}, nil
+}
+
+/* Reads the migrations in dir, sorted by version. Every version needs both an up and a down script. */
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := scriptName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", entry.Name())
+		}
+
+		script, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(script)
+			sum := sha256.Sum256(script)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+/* Creates the table recording applied migrations if it does not exist. */
+func (m *Migrator) init(q queryer) error {
+	_, err := q.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		appliedAt TIMESTAMP NOT NULL
+	);`)
+	return err
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+/* Returns the applied migrations by version. */
+func (m *Migrator) applied(q queryer) (map[int]appliedMigration, error) {
+	if err := m.init(q); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query("SELECT version, checksum, appliedAt FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// Status returns every known migration and whether it has been applied.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i].Migration = migration
+		if a, ok := applied[migration.Version]; ok {
+			appliedAt := a.appliedAt
+			statuses[i].AppliedAt = &appliedAt
+			statuses[i].Modified = a.checksum != migration.Checksum
+		}
+	}
+
+	return statuses, nil
+}
+
+// Version returns the version of the newest applied migration, or 0 when
+// none has been applied.
+func (m *Migrator) Version() (int, error) {
+	applied, err := m.applied(m.db)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the newest applied migration.
+func (m *Migrator) Down() error {
+	version, err := m.Version()
+	if err != nil || version == 0 {
+		return err
+	}
+
+	target := 0
+	for _, migration := range m.migrations {
+		if migration.Version < version {
+			target = migration.Version
+		}
+	}
+
+	return m.To(target)
+}
+
+// To applies or reverts migrations until the schema is at version. Version 0
+// reverts every migration. Nothing is changed when an applied migration was
+// modified or is unknown to this build, or when any migration fails.
+func (m *Migrator) To(version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Another run may have changed the schema while this one waited for the lock
+	if err := m.lock(tx); err != nil {
+		return err
+	}
+	applied, err := m.applied(tx)
+	if err != nil {
+		return err
+	}
+	for v, a := range applied {
+		migration := m.find(v)
+		if migration == nil {
+			return fmt.Errorf("%w %d is applied to the database", ErrUnknownVersion, v)
+		}
+		if a.checksum != migration.Checksum {
+			return fmt.Errorf("%d_%s: %w", v, migration.Name, ErrChecksumMismatch)
+		}
+	}
+
+	// Apply pending migrations oldest first
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := m.apply(tx, migration, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Revert newer migrations newest first
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; ok && migration.Version > version {
+			if err := m.apply(tx, migration, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Key of the Postgres advisory lock held while migrating
+const lockKey = 4618200731
+
+/* Keeps other runs from migrating until the transaction ends. On SQLite the transaction holds the write lock from its start. */
+func (m *Migrator) lock(tx *sql.Tx) error {
+	if m.dialect != "postgres" {
+		return nil
+	}
+
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1);", lockKey)
+	return err
+}
+
+/* Returns the migration with the given version, or nil. */
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+/* Runs the up or down script of a migration and records it. */
+func (m *Migrator) apply(tx *sql.Tx, migration Migration, up bool) error {
+	hook := m.hooks[migration.Version]
+
+	// A migration whose condition does not hold is left pending, for a later
+	// run where it holds to apply
+	if hook.when != nil {
+		run, err := hook.when(tx)
+		if err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if !run && up {
+			return nil
+		}
+		if !run {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, ErrConditionNotMet)
+		}
+	}
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+		if hook.before != nil {
+			if err := hook.before(tx, m.dialect); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	var err error
+	if up {
+		_, err = tx.Exec(m.rebind("INSERT INTO schema_migrations (version, name, checksum, appliedAt) VALUES (?, ?, ?, ?);"),
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.Exec(m.rebind("DELETE FROM schema_migrations WHERE version=?;"), migration.Version)
+	}
+	return err
+}
+
+/* Numbers the ? placeholders of a statement as $1, $2... for Postgres. */
+func (m *Migrator) rebind(query string) string {
+	if m.dialect != "postgres" {
+		return query
+	}
+
+	for n := 1; strings.Contains(query, "?"); n++ {
+		query = strings.Replace(query, "?", "$"+strconv.Itoa(n), 1)
+	}
+	return query
+}
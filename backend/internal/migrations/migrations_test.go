@@ -0,0 +1,230 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/* Opens an empty SQLite database the way the database package does. */
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_fk=true&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestUpAndDown(t *testing.T) {
+	db := openSQLite(t)
+	migrator, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	// Without FTS5 the search index migration stays pending
+	want := migrator.Latest()
+	if fts5, _ := HasFTS5(db); !fts5 {
+		want = 4
+	}
+	if version, _ := migrator.Version(); version != want {
+		t.Fatalf("version after up = %d, want %d", version, want)
+	}
+
+	if err := migrator.To(0); err != nil {
+		t.Fatalf("down to 0: %v", err)
+	}
+	if version, _ := migrator.Version(); version != 0 {
+		t.Fatalf("version after down = %d, want 0", version)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+}
+
+func TestConditionalMigration(t *testing.T) {
+	db := openSQLite(t)
+	migrator, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holds := false
+	migrator.hooks = maps.Clone(migrator.hooks)
+	migrator.hooks[4] = hook{when: func(queryRower) (bool, error) { return holds, nil }}
+
+	deviceColumn := func() bool {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name='device';").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n > 0
+	}
+	pending := func() bool {
+		statuses, err := migrator.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return statuses[3].AppliedAt == nil
+	}
+
+	// A migration whose condition does not hold is neither run nor recorded
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if deviceColumn() || !pending() {
+		t.Fatal("migration 4 was applied although its condition does not hold")
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("up again: %v", err)
+	}
+
+	// A later run where the condition holds applies it
+	holds = true
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("up once the condition holds: %v", err)
+	}
+	if !deviceColumn() || pending() {
+		t.Fatal("migration 4 is still pending once its condition holds")
+	}
+
+	// Reverting it needs the condition as well
+	holds = false
+	if err := migrator.To(3); !errors.Is(err, ErrConditionNotMet) {
+		t.Fatalf("reverting where the condition does not hold: %v, want ErrConditionNotMet", err)
+	}
+	if !deviceColumn() || pending() {
+		t.Fatal("failed revert changed the schema")
+	}
+}
+
+// The schema of databases created before migrations existed
+const legacySchema = `
+CREATE TABLE users (
+	id TEXT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email	TEXT NOT NULL,
+	avatarUrl DATE NOT NULL,
+	accessToken TEXT NOT NULL,
+	expiresAt DATE NOT NULL
+);
+CREATE TABLE todos (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	description	TEXT NOT NULL,
+	done INTEGER NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE TABLE sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	expiresAt DATE NOT NULL,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+INSERT INTO users VALUES ('u1', 'User', 'user@example.com', '', '', '2030-01-01');
+INSERT INTO todos (title, description, done, userId) VALUES ('first', '', 0, 'u1'), ('second', '', 1, 'u1');
+`
+
+func TestUpgradeLegacyDatabase(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := New(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A database that failed to start before the upgrade existed has
+	// 0001_init recorded already
+	if err := migrator.To(1); err != nil {
+		t.Fatalf("migrating to 1: %v", err)
+	}
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("upgrading legacy database: %v", err)
+	}
+
+	for _, column := range legacyColumns {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := columnNames(tx, "sqlite", column.table)
+		tx.Rollback()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !names[strings.ToLower(column.name)] {
+			t.Errorf("%s.%s was not added", column.table, column.name)
+		}
+	}
+
+	rows, err := db.Query("SELECT id, position, version, priority, deletedAt FROM todos ORDER BY id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var id, version, priority int
+		var position float64
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&id, &position, &version, &priority, &deletedAt); err != nil {
+			t.Fatal(err)
+		}
+		if position != float64(id) || version != 1 || priority != 0 || deletedAt.Valid {
+			t.Errorf("todo %d: position %v, version %d, priority %d, deletedAt %v", id, position, version, priority, deletedAt)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("%d todos after upgrade, want 2", count)
+	}
+
+	var timeZone string
+	if err := db.QueryRow("SELECT timeZone FROM users WHERE id='u1';").Scan(&timeZone); err != nil || timeZone != "UTC" {
+		t.Fatalf("user time zone = %q, %v", timeZone, err)
+	}
+}
+
+func TestConcurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Servers starting at once migrate the same database
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			db, err := sql.Open("sqlite3", path+"?_timeout=5000&_fk=true&_txlock=immediate")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+
+			migrator, err := New(db, "sqlite")
+			if err == nil {
+				err = migrator.Up()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent up: %v", err)
+		}
+	}
+}
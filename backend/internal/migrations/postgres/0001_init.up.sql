@@ -0,0 +1,81 @@
+-- Baseline schema, the same tables as on SQLite. Timestamps are stored in UTC
+-- without a time zone.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	avatarUrl TEXT NOT NULL,
+	accessToken TEXT NOT NULL,
+	expiresAt TIMESTAMP NOT NULL,
+	timeZone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+CREATE TABLE IF NOT EXISTS lists (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	archived INTEGER NOT NULL DEFAULT 0,
+	inbox INTEGER NOT NULL DEFAULT 0,
+	position DOUBLE PRECISION NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- A series holds the recurrence rule shared by every occurrence of a
+-- recurring todo
+CREATE TABLE IF NOT EXISTS series (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	rule TEXT NOT NULL,
+	startAt TIMESTAMP NOT NULL,
+	ended INTEGER NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL,
+	done INTEGER NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	position DOUBLE PRECISION NOT NULL DEFAULT 0,
+	listId INTEGER,
+	parentId INTEGER,
+	seriesId INTEGER,
+	dueAt TIMESTAMP,
+	completedAt TIMESTAMP,
+	startAt TIMESTAMP,
+	deletedAt TIMESTAMP,
+	version INTEGER NOT NULL DEFAULT 1,
+	updatedAt TIMESTAMP,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (listId) REFERENCES lists (id) ON DELETE CASCADE,
+	FOREIGN KEY (parentId) REFERENCES todos (id) ON DELETE CASCADE,
+	FOREIGN KEY (seriesId) REFERENCES series (id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	expiresAt TIMESTAMP NOT NULL,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	userId TEXT NOT NULL,
+	UNIQUE (userId, name),
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+	todoId INTEGER NOT NULL,
+	tagId INTEGER NOT NULL,
+	PRIMARY KEY (todoId, tagId),
+	FOREIGN KEY (todoId) REFERENCES todos (id) ON DELETE CASCADE,
+	FOREIGN KEY (tagId) REFERENCES tags (id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS todos_search;
+DROP INDEX IF EXISTS lists_user;
+DROP INDEX IF EXISTS sessions_user;
+DROP INDEX IF EXISTS todos_series;
+DROP INDEX IF EXISTS todos_parent;
+DROP INDEX IF EXISTS todos_user;
@@ -0,0 +1,8 @@
+-- Every todo query is scoped by user
+CREATE INDEX IF NOT EXISTS todos_user ON todos (userId, deletedAt);
+CREATE INDEX IF NOT EXISTS todos_parent ON todos (parentId);
+CREATE INDEX IF NOT EXISTS todos_series ON todos (seriesId);
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (userId);
+CREATE INDEX IF NOT EXISTS lists_user ON lists (userId);
+-- Full text search over titles and bodies, the expression must match search.go
+CREATE INDEX IF NOT EXISTS todos_search ON todos USING GIN (to_tsvector('simple', title || ' ' || description));
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS sessions;
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	expiresAt TIMESTAMP NOT NULL,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (userId);
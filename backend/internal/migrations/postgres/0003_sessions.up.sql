@@ -0,0 +1,20 @@
+-- Sessions are kept on the server. The cookie only carries a random token,
+-- stored here as a hash, and each session records where it was last used
+-- from. Sessions of the old table cannot be carried over and end.
+DROP TABLE IF EXISTS sessions;
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	tokenHash TEXT NOT NULL UNIQUE,
+	userId TEXT NOT NULL,
+	data TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	userAgent TEXT NOT NULL DEFAULT '',
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	lastSeenAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expiresAt TIMESTAMP NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (userId);
+CREATE INDEX IF NOT EXISTS sessions_expiry ON sessions (expiresAt);
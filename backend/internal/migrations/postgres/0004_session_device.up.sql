@@ -0,0 +1,2 @@
+-- Device each session was started on, described from its user agent
+ALTER TABLE sessions ADD COLUMN device TEXT NOT NULL DEFAULT '';
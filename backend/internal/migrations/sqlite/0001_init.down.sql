@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS todo_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS todos;
+DROP TABLE IF EXISTS series;
+DROP TABLE IF EXISTS lists;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,81 @@
+-- Baseline schema. Tables are created only if missing so databases created
+-- before migrations existed are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	avatarUrl DATE NOT NULL,
+	accessToken TEXT NOT NULL,
+	expiresAt DATE NOT NULL,
+	timeZone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+CREATE TABLE IF NOT EXISTS lists (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	archived INTEGER NOT NULL DEFAULT 0,
+	inbox INTEGER NOT NULL DEFAULT 0,
+	position REAL NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- A series holds the recurrence rule shared by every occurrence of a
+-- recurring todo
+CREATE TABLE IF NOT EXISTS series (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	rule TEXT NOT NULL,
+	startAt DATE NOT NULL,
+	ended INTEGER NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS todos (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL,
+	done INTEGER NOT NULL DEFAULT 0,
+	userId TEXT NOT NULL,
+	priority INTEGER NOT NULL DEFAULT 0,
+	position REAL NOT NULL DEFAULT 0,
+	listId INTEGER,
+	parentId INTEGER,
+	seriesId INTEGER,
+	dueAt DATE,
+	completedAt DATE,
+	startAt DATE,
+	deletedAt DATE,
+	version INTEGER NOT NULL DEFAULT 1,
+	updatedAt DATE,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE,
+	FOREIGN KEY (listId) REFERENCES lists (id) ON DELETE CASCADE,
+	FOREIGN KEY (parentId) REFERENCES todos (id) ON DELETE CASCADE,
+	FOREIGN KEY (seriesId) REFERENCES series (id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	expiresAt DATE NOT NULL,
+	userId TEXT NOT NULL,
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL DEFAULT '',
+	userId TEXT NOT NULL,
+	UNIQUE (userId, name),
+	FOREIGN KEY (userId) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+	todoId INTEGER NOT NULL,
+	tagId INTEGER NOT NULL,
+	PRIMARY KEY (todoId, tagId),
+	FOREIGN KEY (todoId) REFERENCES todos (id) ON DELETE CASCADE,
+	FOREIGN KEY (tagId) REFERENCES tags (id) ON DELETE CASCADE
+);
@@ -0,0 +1,25 @@
+-- Full text search over todo titles and bodies, an external content FTS5
+-- table kept in sync with todos by triggers. Databases that created the index
+-- before it was a migration already have it, and are indexed again in case
+-- their triggers were dropped by a build without FTS5.
+CREATE VIRTUAL TABLE IF NOT EXISTS todos_fts USING fts5(
+	title,
+	description,
+	content='todos',
+	content_rowid='id'
+);
+
+CREATE TRIGGER IF NOT EXISTS todos_fts_insert AFTER INSERT ON todos BEGIN
+	INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todos_fts_delete AFTER DELETE ON todos BEGIN
+	INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todos_fts_update AFTER UPDATE OF title, description ON todos BEGIN
+	INSERT INTO todos_fts (todos_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+	INSERT INTO todos_fts (rowid, title, description) VALUES (new.id, new.title, new.description);
+END;
+
+INSERT INTO todos_fts (todos_fts) VALUES ('rebuild');
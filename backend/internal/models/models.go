@@ -1,13 +1,225 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
 type Todo struct {
 	ID    int    `json:"id"`
 	Title string `json:"title"`
 	Done  bool   `json:"done"`
 	Body  string `json:"body"`
+
+	Priority Priority `json:"priority"`
+	Position float64  `json:"position"`
+	Tags     []string `json:"tags"`
+	ListId   *int     `json:"listId"`
+	ParentId *int     `json:"parentId"`
+
+	// Recurrence rule of an active series and the series the todo is an
+	// occurrence of
+	Recurrence string `json:"recurrence,omitempty"`
+	SeriesId   *int   `json:"seriesId,omitempty"`
+
+	// Completion of the direct subtasks of the todo
+	ChildrenDone  int `json:"childrenDone"`
+	ChildrenTotal int `json:"childrenTotal"`
+
+	// Incremented on every change, used for optimistic concurrency
+	Version   int        `json:"version"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+	StartAt     *time.Time `json:"startAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
 }
 
 type NewTodo struct {
 	Title       string `json:"title"`
 	Description string `json:"body"`
+
+	Priority Priority `json:"priority"`
+	// Tag names to attach. Tags that do not exist yet are created. When
+	// editing, leaving tags out keeps the current ones and an empty list
+	// removes them all.
+	Tags []string `json:"tags,omitempty"`
+	// List to put the todo in. New todos default to the inbox and edits
+	// without a list keep the current one.
+	ListId *int `json:"listId,omitempty"`
+	// Parent todo of a new subtask. Use the parent endpoint to move an
+	// existing todo.
+	ParentId *int `json:"parentId,omitempty"`
+	// iCalendar RRULE such as FREQ=WEEKLY;BYDAY=MO. Occurrences repeat from
+	// the due date, so recurring todos need one.
+	Recurrence string `json:"recurrence,omitempty"`
+
+	DueAt   *time.Time `json:"dueAt,omitempty"`
+	StartAt *time.Time `json:"startAt,omitempty"`
+}
+
+// TodoFilter narrows, sorts and pages the todos returned for a user. Unset
+// fields do not filter.
+type TodoFilter struct {
+	Done        *bool
+	DueFrom     *time.Time
+	DueBefore   *time.Time
+	StartFrom   *time.Time
+	StartBefore *time.Time
+	// Todos must have every one of Tags and none of ExcludedTags
+	Tags         []string
+	ExcludedTags []string
+	ListId       *int
+	// Substring of the title or body
+	Text string
+
+	// One of the Sort constants, SortPosition when empty
+	Sort string
+	Desc bool
+	// Page size, zero for all todos, and the cursor of the page to return
+	Limit  int
+	Cursor string
+}
+
+// Keys todos can be sorted by
+const (
+	SortPosition = "position"
+	SortPriority = "priority"
+	SortDueAt    = "dueAt"
+	SortStartAt  = "startAt"
+	SortTitle    = "title"
+	SortCreated  = "created"
+)
+
+// SearchResult is a todo matching a search query with the matched terms of its
+// title and body highlighted using <mark></mark>. The highlights are HTML
+// escaped, so they can be rendered as HTML. Lower ranks match better.
+type SearchResult struct {
+	Todo
+	Highlights struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	} `json:"highlights"`
+	Rank float64 `json:"rank"`
+}
+
+type List struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Color    string  `json:"color"`
+	Archived bool    `json:"archived"`
+	Inbox    bool    `json:"inbox"`
+	Position float64 `json:"position"`
+}
+
+type NewList struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	// Archived and Position are kept as they are on edit when left out. New
+	// lists are active unless archived and are appended at the end
+	Archived *bool    `json:"archived,omitempty"`
+	Position *float64 `json:"position,omitempty"`
+}
+
+type Tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type NewTag struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// Session is a login of a user on one device. The token identifying it is
+// only ever stored as a hash.
+type Session struct {
+	ID        int    `json:"id"`
+	TokenHash string `json:"-"`
+	UserId    string `json:"-"`
+	// Encoded values of the session
+	Data string `json:"-"`
+
+	// Address and browser the session was last used from
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	// Device the user logged in on, such as "Firefox on Windows"
+	Device string `json:"device"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+
+	// Whether this is the session of the request listing the sessions
+	Current bool `json:"current"`
+}
+
+// MoveTodo places a todo between two neighbours. After is the todo that should
+// precede it and Before the todo that should follow it; leaving one out moves
+// the todo to the start or end of the list.
+type MoveTodo struct {
+	After  *int `json:"after,omitempty"`
+	Before *int `json:"before,omitempty"`
+}
+
+// Priority of a todo. It is stored as an integer so it sorts naturally and is
+// exchanged as its name in JSON.
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+func (p Priority) String() string {
+	if p < PriorityNone || p > PriorityUrgent {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ErrUnknownPriority is returned when parsing a name that is not a priority.
+var ErrUnknownPriority = errors.New("unknown priority")
+
+// ParsePriority returns the Priority with the given name.
+func ParsePriority(name string) (Priority, error) {
+	for i, n := range priorityNames {
+		if n == name {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNone, fmt.Errorf("%w %q", ErrUnknownPriority, name)
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	// An empty priority is the same as none
+	if name == "" {
+		*p = PriorityNone
+		return nil
+	}
+
+	parsed, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
 }
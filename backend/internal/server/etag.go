@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// todoETag returns the entity tag of a todo's JSON representation. Hashing the
+// representation rather than using the version alone also changes the tag
+// when only derived fields change, like subtask counts or a renamed tag.
+func todoETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether a comma separated If-Match or If-None-Match
+// header lists the entity tag or is "*". Weak tags match their strong form.
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// marshalTodo encodes a todo and returns it with its entity tag.
+func marshalTodo(todo m.Todo) ([]byte, string, error) {
+	body, err := json.Marshal(todo)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, todoETag(body), nil
+}
+
+// checkIfMatch enforces the If-Match precondition of a todo write. It returns
+// the version of the todo the client has seen, or 0 when the request has no
+// precondition, and false after responding with 412 Precondition Failed when
+// the todo changed since.
+func (s *Server) checkIfMatch(w http.ResponseWriter, r *http.Request, userId string, id int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+
+	todo, err := s.db.Get(r.Context(), id, userId)
+	if errors.Is(err, database.ErrNotFound) {
+		// A missing todo never matches, not even "*"
+		writeError(w, r, preconditionFailed(errors.New("todo does not exist")))
+		return 0, false
+	} else if err != nil {
+		writeError(w, r, err)
+		return 0, false
+	}
+
+	_, etag, err := marshalTodo(todo)
+	if err != nil {
+		writeError(w, r, err)
+		return 0, false
+	}
+
+	if !etagMatches(header, etag) {
+		w.Header().Set("ETag", etag)
+		writeError(w, r, database.ErrVersionConflict)
+		return 0, false
+	}
+
+	return todo.Version, true
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Largest page that can be requested with the limit query parameter
+const maxPageSize = 200
+
+// Header carrying the cursor of the next page of todos
+const nextCursorHeader = "X-Next-Cursor"
+
+// parseTodoFilter applies the filter, sort and paging query parameters of
+// /api/todos on top of filter, which may already hold a computed view.
+//
+//	done=true|false              completion state
+//	dueFrom, dueBefore           due date range, RFC 3339
+//	startFrom, startBefore       start date range, RFC 3339
+//	list=ID                      todos of one list
+//	tag=work&tag=-someday        required and excluded tags
+//	q=text                       substring of the title or body
+//	sort=KEY&order=asc|desc      position, priority, dueAt, startAt, title or created
+//	limit=N&cursor=TOKEN         page size and the X-Next-Cursor of the previous page
+func parseTodoFilter(query url.Values, filter *m.TodoFilter) error {
+	if v := query.Get("done"); v != "" {
+		done, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("done must be true or false")
+		}
+		filter.Done = &done
+	}
+
+	for param, dest := range map[string]**time.Time{
+		"dueFrom":     &filter.DueFrom,
+		"dueBefore":   &filter.DueBefore,
+		"startFrom":   &filter.StartFrom,
+		"startBefore": &filter.StartBefore,
+	} {
+		if v := query.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*dest = &t
+		}
+	}
+
+	if v := query.Get("list"); v != "" {
+		listId, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid list id")
+		}
+		filter.ListId = &listId
+	}
+
+	filter.Tags, filter.ExcludedTags = tagFilter(query["tag"])
+	filter.Text = query.Get("q")
+
+	if v := query.Get("sort"); v != "" {
+		filter.Sort = v
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return fmt.Errorf("order must be asc or desc")
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		filter.Limit = limit
+	}
+	filter.Cursor = query.Get("cursor")
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// validateList trims the list name and checks the optional colour.
+func validateList(list *m.NewList) error {
+	var v validator
+
+	list.Name = strings.TrimSpace(list.Name)
+	v.check(list.Name != "", "name", "is required")
+	v.maxLength(list.Name, "name", maxNameLength)
+	v.check(list.Color == "" || tagColorPattern.MatchString(list.Color), "color", "must be a hex colour like #1e90ff")
+
+	return v.err()
+}
+
+// validateListId checks the list a todo is moved to, which is required.
+func validateListId(listId *int) error {
+	var v validator
+
+	v.check(listId != nil, "listId", "is required")
+	v.id(listId, "listId")
+
+	return v.err()
+}
+
+func (s *Server) getListsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+	includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("archived"))
+
+	lists, err := s.db.GetLists(r.Context(), userId, includeArchived)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(lists)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) createListHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	var body m.NewList
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateList(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if _, err := s.db.CreateList(r.Context(), body, userId); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeLists(w, r, userId, http.StatusCreated)
+}
+
+func (s *Server) editListHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid list id")))
+		return
+	}
+
+	var body m.NewList
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateList(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = s.db.EditList(r.Context(), id, body, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeLists(w, r, userId, http.StatusOK)
+}
+
+func (s *Server) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid list id")))
+		return
+	}
+
+	err = s.db.DeleteList(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeLists(w, r, userId, http.StatusOK)
+}
+
+func (s *Server) moveTodoToListHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
+	}
+
+	var body struct {
+		ListId *int `json:"listId"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateListId(body.ListId); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
+
+	err = s.db.MoveToList(r.Context(), id, *body.ListId, userId, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+// writeLists responds with the user's active lists after a change.
+func (s *Server) writeLists(w http.ResponseWriter, r *http.Request, userId string, status int) {
+	lists, err := s.db.GetLists(r.Context(), userId, false)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(lists)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics turns a panic in a handler into a 500 response and logs its
+// stack trace, so one bad request cannot take the server down.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// Aborting a response is how handlers stop a stream on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			writeError(w, r, fmt.Errorf("panic: %v", rec))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/go-chi/chi/v5"
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Patch formats accepted by PATCH /api/todos/{id}
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patchTodoHandler applies a JSON Merge Patch (RFC 7396) or a JSON Patch
+// (RFC 6902) to the editable fields of a todo: title, body, priority, tags,
+// listId, recurrence, dueAt and startAt. Fields the patch does not touch keep
+// their values. Removing the recurrence ends the todo's series.
+func (s *Server) patchTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
+	}
+
+	// Plain JSON bodies are treated as merge patches
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType && mediaType != "application/json" {
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		writeError(w, r, unsupportedMediaType(fmt.Errorf("unsupported patch format %q", mediaType)))
+		return
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		writeError(w, r, decodeError(err))
+		return
+	}
+
+	s.applyTodoPatch(w, r, userId, id, mediaType, patch)
+}
+
+// applyTodoPatch applies a patch of the given media type to a todo and writes
+// the patched todo.
+func (s *Server) applyTodoPatch(w http.ResponseWriter, r *http.Request, userId string, id int, mediaType string, patch []byte) {
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
+
+	current, err := s.db.Get(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The patch was applied to this version, so a concurrent change must not
+	// be overwritten even when the client sent no precondition
+	if version == 0 {
+		version = current.Version
+	}
+
+	original := editableTodo(current)
+	doc, err := json.Marshal(patchDocument{NewTodo: original, Tags: append([]string{}, original.Tags...)})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var patched []byte
+	if mediaType == jsonPatchType {
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			writeError(w, r, badRequest(err))
+			return
+		}
+		if patched, err = ops.Apply(doc); err != nil {
+			// Failed test operations and missing paths leave the todo unchanged
+			writeError(w, r, newProblem(http.StatusConflict, codeConflict, err))
+			return
+		}
+	} else if patched, err = jsonpatch.MergePatch(doc, patch); err != nil {
+		writeError(w, r, badRequest(err))
+		return
+	}
+
+	var updated m.NewTodo
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&updated); err != nil {
+		writeError(w, r, decodeError(err))
+		return
+	}
+
+	// Removing the tags removes them all rather than keeping the current ones
+	if updated.Tags == nil {
+		updated.Tags = []string{}
+	}
+	// Only a changed rule restarts the series from the due date
+	endSeries := updated.Recurrence == "" && original.Recurrence != ""
+	if updated.Recurrence == original.Recurrence {
+		updated.Recurrence = ""
+	}
+
+	if err := validatePatchedTodo(&updated); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The series ends first, it cannot once the patch removed the due date
+	err = s.db.WithTx(r.Context(), func(tx database.Service) error {
+		if endSeries {
+			if err := tx.EndSeries(r.Context(), id, userId, version); err != nil {
+				return err
+			}
+			version = 0
+		}
+		return tx.Edit(r.Context(), id, updated, userId, version)
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+// patchDocument is the document a patch is applied to. Unlike NewTodo it
+// always has tags, so that operations can add to an empty list.
+type patchDocument struct {
+	m.NewTodo
+	Tags []string `json:"tags"`
+}
+
+// editableTodo returns the fields of a todo that can be patched.
+func editableTodo(todo m.Todo) m.NewTodo {
+	return m.NewTodo{
+		Title:       todo.Title,
+		Description: todo.Body,
+		Priority:    todo.Priority,
+		Tags:        todo.Tags,
+		ListId:      todo.ListId,
+		Recurrence:  todo.Recurrence,
+		DueAt:       todo.DueAt,
+		StartAt:     todo.StartAt,
+	}
+}
+
+// validatePatchedTodo checks a todo after a patch has been applied to it.
+func validatePatchedTodo(todo *m.NewTodo) error {
+	if todo.ParentId != nil {
+		return ValidationError{{Field: "parentId", Message: "cannot be patched, use the parent endpoint"}}
+	}
+	return validateNewTodo(todo)
+}
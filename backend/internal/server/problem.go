@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+)
+
+const problemContentType = "application/problem+json"
+
+// Stable error codes clients can switch on. Messages may change, codes don't.
+const (
+	codeBadRequest           = "bad_request"
+	codeValidation           = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeTimeout              = "timeout"
+	codeInternal             = "internal_error"
+)
+
+// Problem is an error response in the RFC 7807 problem details format, with
+// a stable code as extension member.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+
+	// Every invalid field of a request body, for validation problems
+	Errors ValidationError `json:"errors,omitempty"`
+
+	// The error that caused the problem, only logged
+	err error
+}
+
+func (p *Problem) Error() string {
+	if p.err != nil {
+		return p.err.Error()
+	}
+	return p.Detail
+}
+
+func (p *Problem) Unwrap() error {
+	return p.err
+}
+
+// newProblem returns a problem with the given status and code, described by err.
+func newProblem(status int, code string, err error) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+		Code:   code,
+		err:    err,
+	}
+}
+
+func badRequest(err error) *Problem {
+	return newProblem(http.StatusBadRequest, codeBadRequest, err)
+}
+
+func validationFailed(err error) *Problem {
+	return newProblem(http.StatusUnprocessableEntity, codeValidation, err)
+}
+
+func unauthorized(err error) *Problem {
+	return newProblem(http.StatusUnauthorized, codeUnauthorized, err)
+}
+
+func preconditionFailed(err error) *Problem {
+	return newProblem(http.StatusPreconditionFailed, codePreconditionFailed, err)
+}
+
+func payloadTooLarge(err error) *Problem {
+	return newProblem(http.StatusRequestEntityTooLarge, codePayloadTooLarge, err)
+}
+
+func unsupportedMediaType(err error) *Problem {
+	return newProblem(http.StatusUnsupportedMediaType, codeUnsupportedMediaType, err)
+}
+
+// notFoundHandler responds to requests for paths without a route.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, newProblem(http.StatusNotFound, codeNotFound, fmt.Errorf("no route for %s", r.URL.Path)))
+}
+
+// methodNotAllowedHandler responds to requests with a method the path has no
+// route for, listing the methods it has in the Allow header.
+func methodNotAllowedHandler(routes chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := []string{}
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if routes.Match(chi.NewRouteContext(), method, r.URL.Path) {
+				allowed = append(allowed, method)
+			}
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, newProblem(http.StatusMethodNotAllowed, codeMethodNotAllowed, fmt.Errorf("%s is not allowed on %s, use %s", r.Method, r.URL.Path, strings.Join(allowed, " or "))))
+	}
+}
+
+// problemFor maps an error returned by a handler or the database to a
+// problem. Errors that aren't known to be caused by the request are internal.
+func problemFor(err error) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+
+	var validationErr ValidationError
+	if errors.As(err, &validationErr) {
+		problem := validationFailed(err)
+		problem.Errors = validationErr
+		return problem
+	}
+
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		return newProblem(http.StatusNotFound, codeNotFound, err)
+	case errors.Is(err, database.ErrVersionConflict):
+		return newProblem(http.StatusPreconditionFailed, codePreconditionFailed, err)
+	case errors.Is(err, database.ErrCycle),
+		errors.Is(err, database.ErrNotRecurring),
+		errors.Is(err, database.ErrSeriesFinished),
+		errors.Is(err, database.ErrTagExists),
+		errors.Is(err, database.ErrInboxList):
+		return newProblem(http.StatusConflict, codeConflict, err)
+	case errors.Is(err, database.ErrInvalidRecurrence),
+		errors.Is(err, database.ErrInvalidMove):
+		return newProblem(http.StatusUnprocessableEntity, codeValidation, err)
+	case errors.Is(err, database.ErrInvalidFilter),
+		errors.Is(err, database.ErrInvalidQuery):
+		return newProblem(http.StatusBadRequest, codeBadRequest, err)
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		problem := newProblem(http.StatusServiceUnavailable, codeTimeout, err)
+		problem.Detail = "the request was canceled or took too long"
+		return problem
+	}
+
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+		Code:   codeInternal,
+		err:    err,
+	}
+}
+
+// writeError responds with the problem for err. Internal errors are logged
+// and their details are not sent to the client. Requests canceled by a client
+// that went away are not worth logging.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := *problemFor(err)
+	if problem.Status >= http.StatusInternalServerError && !errors.Is(err, context.Canceled) {
+		log.Println(err)
+	}
+	problem.Instance = r.URL.Path
+
+	jsonResp, err := json.Marshal(problem)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(jsonResp)
+}
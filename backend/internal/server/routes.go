@@ -3,10 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/raziel-aleman/go-todo-app/internal/auth"
 	m "github.com/raziel-aleman/go-todo-app/internal/models"
@@ -19,20 +22,31 @@ import (
 
 type ctxKey string
 
-const providerKey ctxKey = "provider"
+const (
+	providerKey  ctxKey = "provider"
+	userIdKey    ctxKey = "userId"
+	sessionIdKey ctxKey = "sessionId"
+)
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "All"},
-		AllowedMethods:   []string{"GET", "PATCH", "POST"},
+		AllowedHeaders:   []string{"Origin", "Content-Type", "Accept", "All", "If-Match", "If-None-Match"},
+		AllowedMethods:   []string{"GET", "PATCH", "POST", "PUT", "DELETE"},
+		ExposedHeaders:   []string{nextCursorHeader, "Location", "ETag"},
 		AllowCredentials: true,
 	}))
 
 	r.Use(middleware.Logger)
 
+	r.Use(recoverPanics)
+
+	r.NotFound(notFoundHandler)
+
+	r.MethodNotAllowed(methodNotAllowedHandler(r))
+
 	r.Get("/health", s.healthHandler)
 
 	r.Get("/auth/{provider}/callback", s.getAuthCallbackHandler)
@@ -43,19 +57,117 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.Get("/auth/validate", s.validateUserSessionHandler)
 
-	r.Get("/api/todos", auth.RequireAuth(s.getAllTodosHandler))
+	r.Get("/api/todos", s.requireUser(s.getAllTodosHandler))
+
+	r.Post("/api/todos", s.requireUser(s.createTodoHandler))
+
+	r.Put("/api/todos/{id}/done", s.requireUser(s.markTodoDoneHandler))
+
+	r.Delete("/api/todos/{id}/done", s.requireUser(s.markTodoUndoneHandler))
+
+	// Deprecated toggle kept for older clients
+	r.Patch("/api/todos/{id}/done", s.requireUser(s.toggleTodoDoneHandler))
+
+	r.Patch("/api/todos/{id}/edit", s.requireUser(s.editTodoHandler))
+
+	r.Patch("/api/todos/{id}/move", s.requireUser(s.moveTodoHandler))
+
+	r.Get("/api/todos/search", s.requireUser(s.searchTodosHandler))
+
+	r.Get("/api/todos/{id}", s.requireUser(s.getTodoHandler))
+
+	r.Patch("/api/todos/{id}", s.requireUser(s.patchTodoHandler))
+
+	r.Get("/api/todos/{id}/children", s.requireUser(s.getChildrenHandler))
+
+	r.Patch("/api/todos/{id}/parent", s.requireUser(s.setParentHandler))
+
+	r.Post("/api/todos/{id}/skip", s.requireUser(s.skipOccurrenceHandler))
 
-	r.Post("/api/todos", auth.RequireAuth(s.createTodoHandler))
+	r.Post("/api/todos/{id}/end-series", s.requireUser(s.endSeriesHandler))
 
-	r.Patch("/api/todos/{id}/done", auth.RequireAuth(s.markTodoDoneHandler))
+	r.Patch("/api/todos/{id}/list", s.requireUser(s.moveTodoToListHandler))
 
-	r.Patch("/api/todos/{id}/edit", auth.RequireAuth(s.editTodoHandler))
+	r.Get("/api/lists", s.requireUser(s.getListsHandler))
+
+	r.Post("/api/lists", s.requireUser(s.createListHandler))
+
+	r.Patch("/api/lists/{id}", s.requireUser(s.editListHandler))
+
+	r.Delete("/api/lists/{id}", s.requireUser(s.deleteListHandler))
+
+	r.Get("/api/tags", s.requireUser(s.getTagsHandler))
+
+	r.Post("/api/tags", s.requireUser(s.createTagHandler))
+
+	r.Patch("/api/tags/{id}", s.requireUser(s.editTagHandler))
+
+	r.Delete("/api/tags/{id}", s.requireUser(s.deleteTagHandler))
+
+	r.Put("/api/user/timezone", s.requireUser(s.setTimeZoneHandler))
+
+	r.Delete("/api/todos/{id}", s.requireUser(s.deleteTodoHandler))
+
+	r.Get("/api/todos/trash", s.requireUser(s.getTrashHandler))
+
+	r.Post("/api/todos/{id}/restore", s.requireUser(s.restoreTodoHandler))
+
+	r.Get("/api/sessions", s.requireUser(s.getSessionsHandler))
+
+	r.Delete("/api/sessions/others", s.requireUser(s.deleteOtherSessionsHandler))
+
+	r.Delete("/api/sessions/{id}", s.requireUser(s.deleteSessionHandler))
 
 	return r
 }
 
+// requireUser checks that the request carries a valid session and resolves it
+// to the user that owns it. Every todo handler reads the user id from the
+// request context and scopes its queries by it, so a request can only ever see
+// or change its own todos.
+func (s *Server) requireUser(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, userId, err := s.sessions.UserSession(r)
+		if err != nil {
+			writeError(w, r, sessionError(err))
+			return
+		}
+
+		sessionId, _ := strconv.Atoi(session.ID)
+		ctx := context.WithValue(r.Context(), userIdKey, userId)
+		ctx = context.WithValue(ctx, sessionIdKey, sessionId)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+// sessionError maps an error reading the session of a request to the problem
+// it represents: no valid session is unauthorized, anything else failed.
+func sessionError(err error) error {
+	if errors.Is(err, auth.ErrNoSession) {
+		return unauthorized(err)
+	}
+	return err
+}
+
+// userIdFromContext returns the user id stored by requireUser.
+func userIdFromContext(r *http.Request) string {
+	userId, _ := r.Context().Value(userIdKey).(string)
+	return userId
+}
+
+// sessionIdFromContext returns the id of the request's session stored by
+// requireUser.
+func sessionIdFromContext(r *http.Request) int {
+	sessionId, _ := r.Context().Value(sessionIdKey).(int)
+	return sessionId
+}
+
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	jsonResp, _ := json.Marshal(s.db.Health())
+	stats := s.db.Health(r.Context())
+	jsonResp, _ := json.Marshal(stats)
+	if stats["status"] != "up" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	_, _ = w.Write(jsonResp)
 }
 
@@ -74,29 +186,28 @@ func (s *Server) getAuthLoginHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) getAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	// make provider available to the handler
 	providerValue := chi.URLParam(r, "provider")
-	r = r.WithContext(context.WithValue(context.Background(), providerKey, providerValue))
+	r = r.WithContext(context.WithValue(r.Context(), providerKey, providerValue))
 
 	user, err := gothic.CompleteUserAuth(w, r)
 
 	if err != nil {
 		log.Println(err)
-		fmt.Fprintln(w, "Error completing user auth\n", r)
+		writeError(w, r, unauthorized(errors.New("could not complete sign in")))
 		return
 	}
 
-	sessionId, err := auth.StoreUserSession(w, r, user)
-
+	err = s.db.SaveUser(r.Context(), user)
 	if err != nil {
 		log.Println(err)
+		writeError(w, r, err)
 		return
 	}
 
-	msg, err := s.db.SaveUser(user, sessionId)
+	err = s.sessions.StoreUserSession(w, r, user.UserID)
 	if err != nil {
 		log.Println(err)
+		writeError(w, r, err)
 		return
-	} else {
-		log.Println(msg)
 	}
 
 	http.Redirect(w, r, "http://localhost:3000/", http.StatusFound)
@@ -105,7 +216,7 @@ func (s *Server) getAuthCallbackHandler(w http.ResponseWriter, r *http.Request)
 func (s *Server) getAuthLogoutHandler(w http.ResponseWriter, r *http.Request) {
 	gothic.Logout(w, r)
 
-	err := auth.RemoveUserSession(w, r)
+	err := s.sessions.RemoveUserSession(w, r)
 	if err != nil {
 		log.Println(err)
 	}
@@ -115,145 +226,497 @@ func (s *Server) getAuthLogoutHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getAllTodosHandler(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := auth.GetUserSession(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	userId := userIdFromContext(r)
+	query := r.URL.Query()
+
+	var filter m.TodoFilter
+	if view := query.Get("view"); view != "" {
+		loc, err := s.userLocation(r.Context(), userId)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		filter, err = viewFilter(view, time.Now(), loc)
+		if err != nil {
+			writeError(w, r, badRequest(err))
+			return
+		}
+	}
+	if err := parseTodoFilter(query, &filter); err != nil {
+		writeError(w, r, badRequest(err))
+		return
 	}
 
-	userId, err := s.db.IsSessionIdValid(sessionId)
+	rows, next, err := s.db.GetFiltered(r.Context(), userId, filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
-	rows, _ := s.db.GetAll(userId)
+	if next != "" {
+		w.Header().Set(nextCursorHeader, next)
+	}
 
 	jsonResp, err := json.Marshal(rows)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) markTodoDoneHandler(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := auth.GetUserSession(r)
+func (s *Server) getTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
 	}
 
-	userId, err := s.db.IsSessionIdValid(sessionId)
+	todo, err := s.db.Get(r.Context(), id, userId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
-	paramId := chi.URLParam(r, "id")
+	jsonResp, etag, err := marshalTodo(todo)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	id, err := strconv.Atoi(paramId)
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) markTodoDoneHandler(w http.ResponseWriter, r *http.Request) {
+	done := true
+	s.setDone(w, r, &done)
+}
+
+func (s *Server) markTodoUndoneHandler(w http.ResponseWriter, r *http.Request) {
+	done := false
+	s.setDone(w, r, &done)
+}
+
+// toggleTodoDoneHandler flips the done state of a todo. Retried or repeated
+// requests undo each other, so it is deprecated in favour of PUT and DELETE
+// on the same path.
+func (s *Server) toggleTodoDoneHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", r.URL.Path))
+	s.setDone(w, r, nil)
+}
+
+// setDone marks a todo as done or not done, or toggles it when done is nil.
+func (s *Server) setDone(w http.ResponseWriter, r *http.Request, done *bool) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalf("Invalid ID. Err: %v", err)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
 	}
 
-	s.db.MarkDone(int64(id))
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
 
-	rows, _ := s.db.GetAll(userId)
+	// Completing a parent can optionally complete all of its subtasks
+	completeChildren, _ := strconv.ParseBool(r.URL.Query().Get("completeChildren"))
 
-	jsonResp, err := json.Marshal(rows)
+	if done == nil {
+		err = s.db.MarkDone(r.Context(), int64(id), userId, completeChildren, version)
+	} else {
+		err = s.db.SetDone(r.Context(), int64(id), userId, *done, completeChildren, version)
+	}
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	_, _ = w.Write(jsonResp)
+	s.writeTodo(w, r, userId, id, http.StatusOK)
 }
 
 func (s *Server) createTodoHandler(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := auth.GetUserSession(r)
+	userId := userIdFromContext(r)
+
+	var body m.NewTodo
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateNewTodo(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := s.db.Create(r.Context(), body, userId)
 	if err != nil {
-		log.Fatalln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
-	userId, err := s.db.IsSessionIdValid(sessionId)
+	w.Header().Set("Location", fmt.Sprintf("/api/todos/%d", id))
+	s.writeTodo(w, r, userId, id, http.StatusCreated)
+}
+
+// editTodoHandler merges the body into the todo as a JSON Merge Patch, so
+// fields left out keep their values and null clears them.
+func (s *Server) editTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
 	}
 
-	var body m.NewTodo
-	json.NewDecoder(r.Body).Decode(&body)
+	var body json.RawMessage
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	_, err = s.db.Create(body, userId)
+	s.applyTodoPatch(w, r, userId, id, mergePatchType, body)
+}
+
+func (s *Server) moveTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
 
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalf("error creating new todo. Err: %v", err)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
+	}
+
+	var body m.MoveTodo
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateMove(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
+
+	err = s.db.Move(r.Context(), id, body, userId, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	rows, _ := s.db.GetAll(userId)
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+// Number of search results returned when no limit is given, and the most that can be requested
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func (s *Server) searchTodosHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, badRequest(errors.New("missing search query")))
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			writeError(w, r, badRequest(fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)))
+			return
+		}
+		limit = n
+	}
+
+	results, err := s.db.Search(r.Context(), userId, q, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(results)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) getChildrenHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
+	}
+
+	rows, err := s.db.GetChildren(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	jsonResp, err := json.Marshal(rows)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) editTodoHandler(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := auth.GetUserSession(r)
+func (s *Server) setParentHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
+	}
+
+	// A null parent makes the todo a top level todo again
+	var body struct {
+		ParentId *int `json:"parentId"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateParent(body.ParentId); err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	userId, err := s.db.IsSessionIdValid(sessionId)
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
+
+	err = s.db.SetParent(r.Context(), id, body.ParentId, userId, version)
 	if err != nil {
-		log.Fatalln(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
+		return
 	}
 
-	paramId := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(paramId)
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+func (s *Server) skipOccurrenceHandler(w http.ResponseWriter, r *http.Request) {
+	s.seriesHandler(w, r, s.db.SkipOccurrence)
+}
 
+func (s *Server) endSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	s.seriesHandler(w, r, s.db.EndSeries)
+}
+
+// seriesHandler runs an operation on the series of a recurring todo and
+// responds with the changed todo, or with all of the user's todos when the
+// client asked for them with ?return=list.
+func (s *Server) seriesHandler(w http.ResponseWriter, r *http.Request, op func(context.Context, int, string, int) error) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalf("Invalid ID. Err: %v", err)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
 	}
 
-	var body m.NewTodo
-	err = json.NewDecoder(r.Body).Decode(&body)
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
+
+	err = op(r.Context(), id, userId, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+func (s *Server) setTimeZoneHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	var body struct {
+		TimeZone string `json:"timeZone"`
+	}
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if _, err := time.LoadLocation(body.TimeZone); err != nil || body.TimeZone == "" {
+		writeError(w, r, ValidationError{{Field: "timeZone", Message: fmt.Sprintf("unknown time zone %q", body.TimeZone)}})
+		return
+	}
+
+	if err := s.db.SetTimeZone(r.Context(), userId, body.TimeZone); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+// userLocation loads the time zone the user has configured.
+func (s *Server) userLocation(ctx context.Context, userId string) (*time.Location, error) {
+	timeZone, err := s.db.GetTimeZone(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return time.LoadLocation(timeZone)
+}
+
+func (s *Server) deleteTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
+		return
 	}
 
-	err = s.db.Edit(id, body, userId)
+	version, ok := s.checkIfMatch(w, r, userId, id)
+	if !ok {
+		return
+	}
 
+	err = s.db.Delete(r.Context(), id, userId, version)
 	if err != nil {
-		log.Fatal(err)
+		writeError(w, r, err)
+		return
 	}
 
-	rows, _ := s.db.GetAll(userId)
+	if wantsList(r) {
+		s.writeTodos(w, r, userId, http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	rows, err := s.db.GetTrash(r.Context(), userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	jsonResp, err := json.Marshal(rows)
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
 	_, _ = w.Write(jsonResp)
 }
 
-func (s *Server) validateUserSessionHandler(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := auth.GetUserSession(r)
+// restoreTodoHandler takes a todo out of the trash. Unlike the other writes it
+// does not check If-Match: a trashed todo has no ETag a client could have seen,
+// since getting it is not found and deleting it responds without one.
+func (s *Server) restoreTodoHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		//http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResp, _ := json.Marshal(map[string]string{})
-		w.Write(jsonResp)
+		writeError(w, r, badRequest(errors.New("invalid todo id")))
 		return
 	}
 
-	userId, err := s.db.IsSessionIdValid(sessionId)
+	err = s.db.Restore(r.Context(), id, userId)
 	if err != nil {
-		//http.Error(w, err.Error(), http.StatusInternalServerError)
-		jsonResp, _ := json.Marshal(map[string]string{})
-		w.Write(jsonResp)
+		writeError(w, r, err)
+		return
+	}
+
+	s.writeTodo(w, r, userId, id, http.StatusOK)
+}
+
+// wantsList reports whether the client of a todo mutation asked for all of
+// its todos with ?return=list instead of just the changed todo.
+func wantsList(r *http.Request) bool {
+	return r.URL.Query().Get("return") == "list"
+}
+
+// writeTodo responds to a todo mutation with the changed todo and its ETag,
+// or with all of the user's todos when the client opted in with ?return=list.
+func (s *Server) writeTodo(w http.ResponseWriter, r *http.Request, userId string, id int, status int) {
+	if wantsList(r) {
+		s.writeTodos(w, r, userId, status)
+		return
+	}
+
+	todo, err := s.db.Get(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, etag, err := marshalTodo(todo)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResp)
+}
+
+// writeTodos responds with all of the user's todos.
+func (s *Server) writeTodos(w http.ResponseWriter, r *http.Request, userId string, status int) {
+	rows, err := s.db.GetAll(r.Context(), userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(rows)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) validateUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	_, userId, err := s.sessions.UserSession(r)
+	if err != nil {
+		writeError(w, r, sessionError(err))
 		return
 	}
 
@@ -261,8 +724,9 @@ func (s *Server) validateUserSessionHandler(w http.ResponseWriter, r *http.Reque
 
 	jsonResp, err := json.Marshal(map[string]string{"userId": userId})
 	if err != nil {
-		log.Fatalf("error handling JSON marshal. Err: %v", err)
+		writeError(w, r, err)
+		return
 	}
 
-	w.Write(jsonResp)
+	_, _ = w.Write(jsonResp)
 }
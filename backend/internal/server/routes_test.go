@@ -0,0 +1,686 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/raziel-aleman/go-todo-app/internal/auth"
+	"github.com/raziel-aleman/go-todo-app/internal/database"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// testServer serves the API from an in-memory database to logged in users.
+type testServer struct {
+	t        *testing.T
+	handler  http.Handler
+	db       database.Service
+	sessions *auth.Store
+	// Session cookie of each logged in user
+	cookies map[string]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	db := database.NewMemory()
+	s := &Server{db: db, sessions: auth.NewStore(db)}
+
+	return &testServer{
+		t:        t,
+		handler:  s.RegisterRoutes(),
+		db:       db,
+		sessions: s.sessions,
+		cookies:  map[string]string{},
+	}
+}
+
+/* Logs a user in, creating them on their first login, and returns the session cookie. Later requests of the user send the newest cookie. */
+func (ts *testServer) login(userId string) string {
+	ts.t.Helper()
+
+	if err := ts.db.SaveUser(context.Background(), goth.User{UserID: userId, Name: userId}); err != nil {
+		ts.t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := ts.sessions.StoreUserSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), userId); err != nil {
+		ts.t.Fatal(err)
+	}
+	cookie, _, _ := strings.Cut(rec.Header().Get("Set-Cookie"), ";")
+	ts.cookies[userId] = cookie
+	return cookie
+}
+
+/* Sends a request as a user, or without a session when userId is empty. A body is sent as JSON. */
+func (ts *testServer) request(userId string, method string, path string, body string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.requestWith(userId, method, path, body, nil)
+}
+
+/* Sends a request as a user with extra headers, which can override the JSON content type of the body. */
+func (ts *testServer) requestWith(userId string, method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+	ts.t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	if body != "" {
+		req = httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if userId != "" {
+		req.Header.Set("Cookie", ts.cookies[userId])
+	}
+
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// routeTest is a request and the response it should get.
+type routeTest struct {
+	user   string
+	method string
+	path   string
+	body   string
+	status int
+	// Text the response body should contain
+	contains string
+}
+
+/* Sends the requests in order, checking the response to each. */
+func (ts *testServer) run(tests []routeTest) {
+	ts.t.Helper()
+
+	for _, test := range tests {
+		rec := ts.request(test.user, test.method, test.path, test.body)
+		if rec.Code != test.status {
+			ts.t.Errorf("%s %s %s as %q: status %d, want %d: %s", test.method, test.path, test.body, test.user, rec.Code, test.status, rec.Body)
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), test.contains) {
+			ts.t.Errorf("%s %s as %q: body %s does not contain %s", test.method, test.path, test.user, rec.Body, test.contains)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	ts.login("u2")
+
+	// The inboxes of u1 and u2 are lists 1 and 2, and u1 and u2 have sessions 1 and 2
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"Buy milk","tags":["home"],"dueAt":"2026-01-05T10:00:00Z","recurrence":"FREQ=DAILY"}`, http.StatusCreated, `"id":1`},
+		{"u1", "POST", "/api/todos", `{"title":"Report"}`, http.StatusCreated, `"id":2`},
+		{"u1", "POST", "/api/todos", `{"title":"Outline","parentId":2}`, http.StatusCreated, `"parentId":2`},
+		{"u1", "GET", "/api/todos", "", http.StatusOK, `"title":"Outline"`},
+		{"u1", "GET", "/api/todos?tag=home", "", http.StatusOK, `"title":"Buy milk"`},
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"recurrence":"FREQ=DAILY"`},
+		{"u1", "GET", "/api/todos/search?q=milk", "", http.StatusOK, `"title":"Buy milk"`},
+		{"u1", "PATCH", "/api/todos/2/edit", `{"title":"Quarterly report","priority":"high"}`, http.StatusOK, `"priority":"high"`},
+		{"u1", "PATCH", "/api/todos/2", `{"body":"with numbers"}`, http.StatusOK, `"body":"with numbers"`},
+		{"u1", "PATCH", "/api/todos/2/move", `{"before":1}`, http.StatusOK, `"position":0.5`},
+		{"u1", "GET", "/api/todos/2/children", "", http.StatusOK, `"title":"Outline"`},
+		{"u1", "PATCH", "/api/todos/3/parent", `{"parentId":null}`, http.StatusOK, `"parentId":null`},
+		{"u1", "PUT", "/api/todos/3/done", "", http.StatusOK, `"done":true`},
+		{"u1", "DELETE", "/api/todos/3/done", "", http.StatusOK, `"done":false`},
+		{"u1", "PATCH", "/api/todos/3/done", "", http.StatusOK, `"done":true`},
+		{"u1", "POST", "/api/todos/1/skip", "", http.StatusOK, `"dueAt":"2026-01-06T10:00:00Z"`},
+		{"u1", "POST", "/api/todos/1/end-series", "", http.StatusOK, `"id":1`},
+		{"u1", "POST", "/api/todos/1/skip", "", http.StatusConflict, `"status":409`},
+		{"u1", "POST", "/api/lists", `{"name":"Work"}`, http.StatusCreated, `"name":"Work"`},
+		{"u1", "GET", "/api/lists", "", http.StatusOK, `"inbox":true`},
+		{"u1", "PATCH", "/api/lists/3", `{"name":"Office"}`, http.StatusOK, `"name":"Office"`},
+		{"u1", "PATCH", "/api/todos/2/list", `{"listId":3}`, http.StatusOK, `"listId":3`},
+		{"u1", "POST", "/api/tags", `{"name":"errand"}`, http.StatusCreated, `"name":"errand"`},
+		{"u1", "GET", "/api/tags", "", http.StatusOK, `"name":"home"`},
+		{"u1", "PATCH", "/api/tags/2", `{"name":"chore"}`, http.StatusOK, `"name":"chore"`},
+		{"u1", "DELETE", "/api/tags/2", "", http.StatusNoContent, ""},
+		{"u1", "PUT", "/api/user/timezone", `{"timeZone":"Europe/Berlin"}`, http.StatusOK, `"timeZone":"Europe/Berlin"`},
+		{"u1", "DELETE", "/api/todos/3", "", http.StatusNoContent, ""},
+		{"u1", "GET", "/api/todos/trash", "", http.StatusOK, `"title":"Outline"`},
+		{"u1", "POST", "/api/todos/3/restore", "", http.StatusOK, `"title":"Outline"`},
+		{"u1", "DELETE", "/api/lists/3", "", http.StatusOK, `"inbox":true`},
+		{"u1", "GET", "/api/todos/trash", "", http.StatusOK, `"title":"Quarterly report"`},
+		{"u1", "GET", "/api/sessions", "", http.StatusOK, `"current":true`},
+	})
+
+	// Log u1 in on a second device, then out of the first one from the second
+	first := ts.cookies["u1"]
+	ts.login("u1")
+	ts.run([]routeTest{
+		{"u1", "DELETE", "/api/sessions/1", "", http.StatusNoContent, ""},
+		{"u1", "DELETE", "/api/sessions/others", "", http.StatusOK, `"revoked":0`},
+	})
+	ts.cookies["u1"] = first
+	ts.run([]routeTest{
+		{"u1", "GET", "/api/todos", "", http.StatusUnauthorized, ""},
+	})
+}
+
+func TestRoutesRequireLogin(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/todos"},
+		{"POST", "/api/todos"},
+		{"GET", "/api/todos/1"},
+		{"PATCH", "/api/todos/1"},
+		{"DELETE", "/api/todos/1"},
+		{"PUT", "/api/todos/1/done"},
+		{"DELETE", "/api/todos/1/done"},
+		{"PATCH", "/api/todos/1/done"},
+		{"PATCH", "/api/todos/1/edit"},
+		{"PATCH", "/api/todos/1/move"},
+		{"GET", "/api/todos/search?q=milk"},
+		{"GET", "/api/todos/1/children"},
+		{"PATCH", "/api/todos/1/parent"},
+		{"POST", "/api/todos/1/skip"},
+		{"POST", "/api/todos/1/end-series"},
+		{"PATCH", "/api/todos/1/list"},
+		{"GET", "/api/todos/trash"},
+		{"POST", "/api/todos/1/restore"},
+		{"GET", "/api/lists"},
+		{"POST", "/api/lists"},
+		{"PATCH", "/api/lists/1"},
+		{"DELETE", "/api/lists/1"},
+		{"GET", "/api/tags"},
+		{"POST", "/api/tags"},
+		{"PATCH", "/api/tags/1"},
+		{"DELETE", "/api/tags/1"},
+		{"PUT", "/api/user/timezone"},
+		{"GET", "/api/sessions"},
+		{"DELETE", "/api/sessions/others"},
+		{"DELETE", "/api/sessions/1"},
+	} {
+		if rec := ts.request("", route.method, route.path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a session: status %d, want %d", route.method, route.path, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestOtherUsersRecordsAreNotFound(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	ts.login("u2")
+
+	// u1 has todos 1 to 4, of which 4 is in the trash, list 3, tag 1 and
+	// session 1. u2 has todo 5, their inbox list 2 and session 2.
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"secret milk","tags":["secret"],"dueAt":"2026-01-05T10:00:00Z","recurrence":"FREQ=DAILY"}`, http.StatusCreated, `"id":1`},
+		{"u1", "POST", "/api/todos", `{"title":"secret report"}`, http.StatusCreated, `"id":2`},
+		{"u1", "POST", "/api/todos", `{"title":"secret outline","parentId":2}`, http.StatusCreated, `"id":3`},
+		{"u1", "POST", "/api/todos", `{"title":"secret trash"}`, http.StatusCreated, `"id":4`},
+		{"u1", "DELETE", "/api/todos/4", "", http.StatusNoContent, ""},
+		{"u1", "POST", "/api/lists", `{"name":"secret list"}`, http.StatusCreated, `"id":3`},
+		{"u2", "POST", "/api/todos", `{"title":"mine"}`, http.StatusCreated, `"id":5`},
+	})
+
+	notFound := []routeTest{
+		{"u2", "GET", "/api/todos/1", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/1", `{"title":"taken"}`, http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/1/edit", `{"title":"taken"}`, http.StatusNotFound, ""},
+		{"u2", "PUT", "/api/todos/1/done", "", http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/todos/1/done", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/1/done", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/1/move", `{"before":5}`, http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/5/move", `{"after":1}`, http.StatusNotFound, ""},
+		{"u2", "GET", "/api/todos/2/children", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/3/parent", `{"parentId":5}`, http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/5/parent", `{"parentId":2}`, http.StatusNotFound, ""},
+		{"u2", "POST", "/api/todos/1/skip", "", http.StatusNotFound, ""},
+		{"u2", "POST", "/api/todos/1/end-series", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/1/list", `{"listId":2}`, http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/todos/5/list", `{"listId":3}`, http.StatusNotFound, ""},
+		{"u2", "POST", "/api/todos", `{"title":"into their list","listId":3}`, http.StatusNotFound, ""},
+		{"u2", "POST", "/api/todos", `{"title":"under their todo","parentId":2}`, http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/todos/1", "", http.StatusNotFound, ""},
+		{"u2", "POST", "/api/todos/4/restore", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/lists/3", `{"name":"taken"}`, http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/lists/3", "", http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/lists/1", "", http.StatusNotFound, ""},
+		{"u2", "PATCH", "/api/tags/1", `{"name":"taken"}`, http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/tags/1", "", http.StatusNotFound, ""},
+		{"u2", "DELETE", "/api/sessions/1", "", http.StatusNotFound, ""},
+	}
+	ts.run(notFound)
+
+	// Listings only show the user's own records
+	for _, path := range []string{"/api/todos", "/api/todos/trash", "/api/todos/search?q=secret", "/api/todos?q=secret", "/api/lists", "/api/tags"} {
+		rec := ts.request("u2", "GET", path, "")
+		if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("GET %s as u2: status %d, body %s", path, rec.Code, rec.Body)
+		}
+	}
+	if rec := ts.request("u2", "GET", "/api/sessions", ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), `"id":1`) {
+		t.Errorf("GET /api/sessions as u2: status %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := ts.request("u2", "DELETE", "/api/sessions/others", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"revoked":0`) {
+		t.Errorf("DELETE /api/sessions/others as u2: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// Nothing of u1 was changed
+	ts.run([]routeTest{
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"title":"secret milk","done":false`},
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"version":1`},
+		{"u1", "GET", "/api/todos/3", "", http.StatusOK, `"parentId":2`},
+		{"u1", "GET", "/api/todos/trash", "", http.StatusOK, `"title":"secret trash"`},
+		{"u1", "GET", "/api/lists", "", http.StatusOK, `"name":"secret list"`},
+		{"u1", "GET", "/api/tags", "", http.StatusOK, `"name":"secret"`},
+	})
+}
+
+func TestEditKeepsOmittedFields(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+
+	// The edit form only sends the title and body
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"Buy milk","dueAt":"2026-01-05T10:00:00Z","startAt":"2026-01-05T08:00:00Z","recurrence":"FREQ=DAILY"}`, http.StatusCreated, `"id":1`},
+		{"u1", "PATCH", "/api/todos/1/edit", `{"title":"Buy oat milk","body":"the barista one"}`, http.StatusOK, `"dueAt":"2026-01-05T10:00:00Z","startAt":"2026-01-05T08:00:00Z"`},
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"recurrence":"FREQ=DAILY"`},
+		{"u1", "POST", "/api/todos/1/skip", "", http.StatusOK, `"dueAt":"2026-01-06T10:00:00Z"`},
+		{"u1", "PUT", "/api/todos/1/done", "", http.StatusOK, `"done":true`},
+		{"u1", "GET", "/api/todos", "", http.StatusOK, `"dueAt":"2026-01-07T10:00:00Z"`},
+		// null clears a field
+		{"u1", "PATCH", "/api/todos/1/edit", `{"startAt":null}`, http.StatusOK, `"title":"Buy oat milk"`},
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"dueAt":"2026-01-06T10:00:00Z","completedAt"`},
+	})
+}
+
+func TestEditKeepsPriority(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"Report","priority":"high"}`, http.StatusCreated, `"priority":"high"`},
+		{"u1", "PATCH", "/api/todos/1/edit", `{"title":"Quarterly report","body":"with numbers"}`, http.StatusOK, `"priority":"high"`},
+		{"u1", "PATCH", "/api/todos/1", `{"body":"with charts"}`, http.StatusOK, `"priority":"high"`},
+		{"u1", "PATCH", "/api/todos/1/edit", `{"priority":"low"}`, http.StatusOK, `"priority":"low"`},
+		// null resets the priority to none
+		{"u1", "PATCH", "/api/todos/1/edit", `{"priority":null}`, http.StatusOK, `"priority":"none"`},
+	})
+}
+
+func TestProblemResponses(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+
+	for _, test := range []struct {
+		user, method, path, body string
+		status                   int
+		code                     string
+	}{
+		{"u1", "GET", "/api/nothing", "", http.StatusNotFound, codeNotFound},
+		{"", "GET", "/nothing", "", http.StatusNotFound, codeNotFound},
+		{"u1", "POST", "/api/todos/1", `{"title":"todo"}`, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"", "PUT", "/health", "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{"u1", "POST", "/api/todos", `{"title":" "}`, http.StatusUnprocessableEntity, codeValidation},
+		{"u1", "POST", "/api/todos", `{"title":`, http.StatusBadRequest, codeBadRequest},
+		{"u1", "GET", "/api/todos/99", "", http.StatusNotFound, codeNotFound},
+		{"", "GET", "/api/todos", "", http.StatusUnauthorized, codeUnauthorized},
+	} {
+		rec := ts.request(test.user, test.method, test.path, test.body)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: status %d, want %d", test.method, test.path, rec.Code, test.status)
+			continue
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != problemContentType {
+			t.Errorf("%s %s: Content-Type %q, want %q", test.method, test.path, contentType, problemContentType)
+		}
+
+		var problem Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Errorf("%s %s: body %s is not a problem: %v", test.method, test.path, rec.Body, err)
+			continue
+		}
+		if problem.Status != test.status || problem.Code != test.code || problem.Title != http.StatusText(test.status) || problem.Instance != test.path {
+			t.Errorf("%s %s: problem %+v, want status %d and code %s", test.method, test.path, problem, test.status, test.code)
+		}
+	}
+
+	// Validation problems list every invalid field
+	rec := ts.request("u1", "POST", "/api/todos", `{"title":"","listId":0}`)
+	if !strings.Contains(rec.Body.String(), `"errors":[{"field":"title"`) || !strings.Contains(rec.Body.String(), `"field":"listId"`) {
+		t.Errorf("validation problem %s does not list the invalid fields", rec.Body)
+	}
+
+	// The methods a path has are listed when another one is used
+	if allow := ts.request("u1", "POST", "/api/todos/1", "").Header().Get("Allow"); allow != "GET, PATCH, DELETE" {
+		t.Errorf("Allow: %q, want %q", allow, "GET, PATCH, DELETE")
+	}
+}
+
+func TestListRequestsAreValidated(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+
+	// The inbox of u1 is list 1
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"Report"}`, http.StatusCreated, `"id":1`},
+		{"u1", "POST", "/api/lists", `{"name":"Work"}`, http.StatusCreated, `"id":2`},
+		{"u1", "PATCH", "/api/todos/1/list", `{}`, http.StatusUnprocessableEntity, `"field":"listId","message":"is required"`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":null}`, http.StatusUnprocessableEntity, `"field":"listId","message":"is required"`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":0}`, http.StatusUnprocessableEntity, `"field":"listId","message":"must be a positive id"`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":-2}`, http.StatusUnprocessableEntity, `"field":"listId","message":"must be a positive id"`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":"2"}`, http.StatusUnprocessableEntity, `"field":"listId"`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":9}`, http.StatusNotFound, `"code":"not_found"`},
+		{"u1", "GET", "/api/todos/1", "", http.StatusOK, `"listId":1`},
+		{"u1", "PATCH", "/api/todos/1/list", `{"listId":2}`, http.StatusOK, `"listId":2`},
+
+		// Edits that leave archived out keep the list archived
+		{"u1", "PATCH", "/api/lists/2", `{"name":"Work","archived":true}`, http.StatusOK, ""},
+		{"u1", "PATCH", "/api/lists/2", `{"name":"Office"}`, http.StatusOK, ""},
+		{"u1", "GET", "/api/lists?archived=true", "", http.StatusOK, `"name":"Office","color":"","archived":true`},
+		{"u1", "PATCH", "/api/lists/2", `{"name":"Office","archived":false}`, http.StatusOK, `"name":"Office","color":"","archived":false`},
+		{"u1", "PATCH", "/api/lists/1", `{"name":"Inbox","archived":true}`, http.StatusConflict, `"code":"conflict"`},
+	})
+}
+
+// panickingDB panics when a user's todos are listed.
+type panickingDB struct {
+	database.Service
+}
+
+func (db panickingDB) GetFiltered(ctx context.Context, userId string, filter m.TodoFilter) ([]m.Todo, string, error) {
+	panic("listing todos")
+}
+
+func TestPanicsBecomeProblems(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	ts.handler = (&Server{db: panickingDB{ts.db}, sessions: ts.sessions}).RegisterRoutes()
+
+	rec := ts.request("u1", "GET", "/api/todos", "")
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("status %d with Content-Type %q, want a %d problem", rec.Code, rec.Header().Get("Content-Type"), http.StatusInternalServerError)
+	}
+	// The panic is logged, not sent to the client
+	if !strings.Contains(rec.Body.String(), `"code":"internal_error"`) || strings.Contains(rec.Body.String(), "listing todos") {
+		t.Errorf("body %s", rec.Body)
+	}
+
+	// The server keeps serving other requests
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/todos", `{"title":"Report"}`, http.StatusCreated, `"id":1`},
+	})
+}
+
+func TestSessionRoutes(t *testing.T) {
+	ts := newTestServer(t)
+
+	// u1 logs in on a laptop and a phone, u2 on one device. The sessions of
+	// the laptop, u2 and the phone are 1, 2 and 3.
+	laptop := ts.login("u1")
+	ts.login("u2")
+	phone := ts.login("u1")
+	as := func(cookie string) { ts.cookies["u1"] = cookie }
+
+	as(phone)
+	ts.run([]routeTest{
+		{"u1", "GET", "/api/sessions", "", http.StatusOK, `"id":3`},
+		{"u1", "GET", "/api/sessions", "", http.StatusOK, `"id":1`},
+		// Another user's session is not found and stays logged in
+		{"u1", "DELETE", "/api/sessions/2", "", http.StatusNotFound, `"code":"not_found"`},
+		{"u1", "DELETE", "/api/sessions/99", "", http.StatusNotFound, `"code":"not_found"`},
+		{"u1", "DELETE", "/api/sessions/first", "", http.StatusBadRequest, `"code":"bad_request"`},
+		{"u2", "GET", "/api/sessions", "", http.StatusOK, `"id":2`},
+	})
+
+	// Only the session of the request is current
+	rec := ts.request("u1", "GET", "/api/sessions", "")
+	var sessions []struct {
+		ID      int  `json:"id"`
+		Current bool `json:"current"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil || len(sessions) != 2 {
+		t.Fatalf("sessions of u1 = %s, %v", rec.Body, err)
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID == 3) {
+			t.Errorf("session %d current = %v", session.ID, session.Current)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "token") || strings.Contains(rec.Body.String(), laptop) {
+		t.Errorf("sessions of u1 = %s reveal tokens", rec.Body)
+	}
+
+	// Logging out of the other devices ends the laptop's session only
+	ts.run([]routeTest{
+		{"u1", "DELETE", "/api/sessions/others", "", http.StatusOK, `"revoked":1`},
+		{"u1", "GET", "/api/todos", "", http.StatusOK, ""},
+		{"u2", "GET", "/api/todos", "", http.StatusOK, ""},
+	})
+	as(laptop)
+	ts.run([]routeTest{
+		{"u1", "GET", "/api/todos", "", http.StatusUnauthorized, ""},
+	})
+
+	// Logging in on the laptop again and out of the phone from it
+	laptop = ts.login("u1")
+	ts.run([]routeTest{
+		{"u1", "DELETE", "/api/sessions/3", "", http.StatusNoContent, ""},
+		{"u1", "DELETE", "/api/sessions/3", "", http.StatusNotFound, ""},
+		{"u1", "GET", "/api/sessions", "", http.StatusOK, `"current":true`},
+	})
+	as(phone)
+	ts.run([]routeTest{
+		{"u1", "GET", "/api/todos", "", http.StatusUnauthorized, ""},
+	})
+
+	// Revoking the session of the request logs out the device making it
+	as(laptop)
+	ts.run([]routeTest{
+		{"u1", "DELETE", "/api/sessions/4", "", http.StatusNoContent, ""},
+		{"u1", "GET", "/api/sessions", "", http.StatusUnauthorized, ""},
+		{"u2", "GET", "/api/sessions", "", http.StatusOK, `"id":2`},
+	})
+}
+
+/* Creates a recurring todo for u1 and returns its id and ETag. */
+func (ts *testServer) etagTodo() (int, string) {
+	ts.t.Helper()
+
+	rec := ts.request("u1", "POST", "/api/todos", `{"title":"Buy milk","dueAt":"2026-01-05T10:00:00Z","recurrence":"FREQ=DAILY"}`)
+	var todo m.Todo
+	if err := json.Unmarshal(rec.Body.Bytes(), &todo); err != nil || rec.Code != http.StatusCreated {
+		ts.t.Fatalf("creating a todo: %d %s", rec.Code, rec.Body)
+	}
+	etag := ts.request("u1", "GET", fmt.Sprintf("/api/todos/%d", todo.ID), "").Header().Get("ETag")
+	if etag == "" {
+		ts.t.Fatal("todo has no ETag")
+	}
+	return todo.ID, etag
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	id, etag := ts.etagTodo()
+	path := fmt.Sprintf("/api/todos/%d", id)
+
+	for _, test := range []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"stale", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"stale"`, http.StatusOK},
+		{"", http.StatusOK},
+	} {
+		rec := ts.requestWith("u1", "GET", path, "", http.Header{"If-None-Match": {test.ifNoneMatch}})
+		if rec.Code != test.status || rec.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: status %d with ETag %s, want %d with %s", test.ifNoneMatch, rec.Code, rec.Header().Get("ETag"), test.status, etag)
+		}
+		if test.status == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: 304 with body %s", test.ifNoneMatch, rec.Body)
+		}
+	}
+
+	// Changing the todo changes its tag
+	ts.request("u1", "PATCH", path, `{"title":"Buy oat milk"}`)
+	if rec := ts.requestWith("u1", "GET", path, "", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("If-None-Match of the old tag after an edit: status %d with ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+
+	// Todo 1 is the neighbour and parent the other todos move next to and
+	// under, list 2 the list they move to
+	ts.etagTodo()
+	ts.run([]routeTest{
+		{"u1", "POST", "/api/lists", `{"name":"Work"}`, http.StatusCreated, `"id":2`},
+	})
+
+	for _, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"PATCH", "", `{"title":"Buy oat milk"}`, http.StatusOK},
+		{"PATCH", "/edit", `{"title":"Buy oat milk"}`, http.StatusOK},
+		{"PATCH", "/move", `{"before":1}`, http.StatusOK},
+		{"PATCH", "/parent", `{"parentId":1}`, http.StatusOK},
+		{"PATCH", "/list", `{"listId":2}`, http.StatusOK},
+		{"PUT", "/done", "", http.StatusOK},
+		{"DELETE", "/done", "", http.StatusOK},
+		{"PATCH", "/done", "", http.StatusOK},
+		{"POST", "/skip", "", http.StatusOK},
+		{"POST", "/end-series", "", http.StatusOK},
+		{"DELETE", "", "", http.StatusNoContent},
+	} {
+		name := test.method + " /api/todos/{id}" + test.path
+
+		// A stale tag changes nothing and is told the current one
+		id, etag := ts.etagTodo()
+		path := fmt.Sprintf("/api/todos/%d%s", id, test.path)
+		rec := ts.requestWith("u1", test.method, path, test.body, http.Header{"If-Match": {`"stale"`}})
+		if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != etag || !strings.Contains(rec.Body.String(), `"code":"precondition_failed"`) {
+			t.Errorf("%s with a stale tag: status %d with ETag %s: %s", name, rec.Code, rec.Header().Get("ETag"), rec.Body)
+		}
+		if current := ts.request("u1", "GET", fmt.Sprintf("/api/todos/%d", id), "").Header().Get("ETag"); current != etag {
+			t.Errorf("%s with a stale tag changed the todo", name)
+		}
+
+		// The current tag lets the write through, once
+		rec = ts.requestWith("u1", test.method, path, test.body, http.Header{"If-Match": {etag}})
+		if rec.Code != test.status {
+			t.Errorf("%s with the current tag: status %d, want %d: %s", name, rec.Code, test.status, rec.Body)
+		}
+		if test.status == http.StatusOK && rec.Header().Get("ETag") == "" {
+			t.Errorf("%s: response has no ETag", name)
+		}
+
+		// Without a tag the write is not conditional
+		id, _ = ts.etagTodo()
+		path = fmt.Sprintf("/api/todos/%d%s", id, test.path)
+		if rec := ts.request("u1", test.method, path, test.body); rec.Code != test.status {
+			t.Errorf("%s without a tag: status %d, want %d: %s", name, rec.Code, test.status, rec.Body)
+		}
+
+		// Nothing matches a todo that does not exist, not even *
+		path = fmt.Sprintf("/api/todos/99%s", test.path)
+		if rec := ts.requestWith("u1", test.method, path, test.body, http.Header{"If-Match": {"*"}}); rec.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s of a missing todo with *: status %d, want %d", name, rec.Code, http.StatusPreconditionFailed)
+		}
+	}
+}
+
+func TestIfMatchPreventsLostUpdates(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	id, etag := ts.etagTodo()
+	path := fmt.Sprintf("/api/todos/%d", id)
+
+	// Two clients read the todo, the first one to write wins
+	first := ts.requestWith("u1", "PATCH", path, `{"title":"first"}`, http.Header{"If-Match": {etag}})
+	second := ts.requestWith("u1", "PATCH", path, `{"title":"second"}`, http.Header{"If-Match": {etag}})
+	if first.Code != http.StatusOK || second.Code != http.StatusPreconditionFailed {
+		t.Fatalf("statuses %d and %d, want %d and %d", first.Code, second.Code, http.StatusOK, http.StatusPreconditionFailed)
+	}
+	if second.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("412 tells tag %s, the first write made %s", second.Header().Get("ETag"), first.Header().Get("ETag"))
+	}
+
+	// Any of several tags, or * for any version, matches
+	ts.run([]routeTest{
+		{"u1", "GET", path, "", http.StatusOK, `"title":"first"`},
+	})
+	rec := ts.requestWith("u1", "PATCH", path, `{"title":"third"}`, http.Header{"If-Match": {etag + ", " + first.Header().Get("ETag")}})
+	if rec.Code != http.StatusOK {
+		t.Errorf("If-Match listing the current tag: status %d", rec.Code)
+	}
+	if rec := ts.requestWith("u1", "PATCH", path, `{"title":"fourth"}`, http.Header{"If-Match": {"*"}}); rec.Code != http.StatusOK {
+		t.Errorf("If-Match *: status %d", rec.Code)
+	}
+
+	// Trashed todos have no tag, so restoring one is not conditional
+	ts.request("u1", "DELETE", path, "")
+	if rec := ts.requestWith("u1", "POST", path+"/restore", "", http.Header{"If-Match": {etag}}); rec.Code != http.StatusOK || rec.Header().Get("ETag") == "" {
+		t.Errorf("restoring with If-Match: status %d with ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login("u1")
+	id, _ := ts.etagTodo()
+	path := fmt.Sprintf("/api/todos/%d", id)
+	jsonPatch := http.Header{"Content-Type": {jsonPatchType}}
+
+	for _, test := range []struct {
+		name   string
+		patch  string
+		status int
+		// Text the response contains
+		contains string
+	}{
+		{"replace", `[{"op":"replace","path":"/title","value":"Buy oat milk"},{"op":"add","path":"/tags/-","value":"home"}]`, http.StatusOK, `"title":"Buy oat milk"`},
+		{"passing test", `[{"op":"test","path":"/title","value":"Buy oat milk"},{"op":"replace","path":"/priority","value":"high"}]`, http.StatusOK, `"priority":"high"`},
+		{"remove", `[{"op":"remove","path":"/dueAt"},{"op":"remove","path":"/recurrence"}]`, http.StatusOK, `"title":"Buy oat milk"`},
+		{"failing test", `[{"op":"replace","path":"/title","value":"changed"},{"op":"test","path":"/title","value":"Buy milk"}]`, http.StatusConflict, `"code":"conflict"`},
+		{"missing path", `[{"op":"replace","path":"/nothing/here","value":1}]`, http.StatusConflict, `"code":"conflict"`},
+		{"not a list of operations", `{"op":"replace","path":"/title","value":"changed"}`, http.StatusBadRequest, `"code":"bad_request"`},
+		{"malformed", `[{"op":"replace",`, http.StatusBadRequest, `"code":"bad_request"`},
+		{"invalid result", `[{"op":"replace","path":"/title","value":""}]`, http.StatusUnprocessableEntity, `"field":"title"`},
+		{"unknown field", `[{"op":"add","path":"/owner","value":"u2"}]`, http.StatusUnprocessableEntity, `"field":"owner"`},
+	} {
+		before := ts.request("u1", "GET", path, "")
+
+		rec := ts.requestWith("u1", "PATCH", path, test.patch, jsonPatch)
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.contains) {
+			t.Errorf("%s: status %d, want %d with %s: %s", test.name, rec.Code, test.status, test.contains, rec.Body)
+			continue
+		}
+
+		// A patch that fails is not applied at all
+		after := ts.request("u1", "GET", path, "")
+		if test.status != http.StatusOK && after.Header().Get("ETag") != before.Header().Get("ETag") {
+			t.Errorf("%s: failed patch changed the todo to %s", test.name, after.Body)
+		}
+	}
+
+	ts.run([]routeTest{
+		{"u1", "GET", path, "", http.StatusOK, `"title":"Buy oat milk","done":false,"body":"","priority":"high"`},
+		{"u1", "GET", path, "", http.StatusOK, `"tags":["home"]`},
+	})
+	if body := ts.request("u1", "GET", path, "").Body.String(); strings.Contains(body, "dueAt") || strings.Contains(body, "recurrence") {
+		t.Errorf("removed fields are still set: %s", body)
+	}
+
+	// Other patch formats are refused, naming the accepted ones
+	rec := ts.requestWith("u1", "PATCH", path, `title=changed`, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Header().Get("Accept-Patch"), jsonPatchType) {
+		t.Errorf("form patch: status %d with Accept-Patch %q", rec.Code, rec.Header().Get("Accept-Patch"))
+	}
+}
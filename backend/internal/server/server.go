@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -9,6 +11,7 @@ import (
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/raziel-aleman/go-todo-app/internal/auth"
 	"github.com/raziel-aleman/go-todo-app/internal/database"
 )
 
@@ -16,15 +19,40 @@ type Server struct {
 	port int
 
 	db database.Service
+
+	sessions *auth.Store
 }
 
-func NewServer() *http.Server {
+const (
+	// Default number of days a todo stays in the trash before it is purged
+	defaultTrashRetentionDays = 30
+	// How often the trash purge runs
+	trashPurgeInterval = time.Hour
+	// How often expired sessions are deleted
+	sessionPurgeInterval = time.Hour
+)
+
+func NewServer() (*http.Server, error) {
+	db, err := database.New()
+	if err != nil {
+		return nil, err
+	}
+
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
 	NewServer := &Server{
 		port: port,
 
-		db: database.New(),
+		db: db,
+
+		sessions: auth.NewStore(db),
+	}
+
+	retentionDays, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || retentionDays <= 0 {
+		retentionDays = defaultTrashRetentionDays
 	}
+	go NewServer.purgeTrash(retentionDays, trashPurgeInterval)
+	go NewServer.purgeSessions(sessionPurgeInterval)
 
 	// Declare Server config
 	server := &http.Server{
@@ -35,5 +63,40 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return server, nil
+}
+
+// purgeTrash periodically removes todos that have been in the trash for
+// longer than retentionDays.
+func (s *Server) purgeTrash(retentionDays int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		n, err := s.db.PurgeTrash(context.Background(), retentionDays)
+		if err != nil {
+			log.Printf("error purging trash: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("purged %d todos from trash", n)
+		}
+	}
+}
+
+// purgeSessions periodically deletes expired sessions.
+func (s *Server) purgeSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		n, err := s.db.PurgeSessions(context.Background())
+		if err != nil {
+			log.Printf("error purging sessions: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("purged %d expired sessions", n)
+		}
+	}
 }
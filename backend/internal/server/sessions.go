@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getSessionsHandler lists the devices the user is logged in on, marking the
+// session of the request as current.
+func (s *Server) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	sessions, err := s.db.GetSessions(r.Context(), userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionIdFromContext(r)
+	}
+
+	jsonResp, err := json.Marshal(sessions)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+// deleteSessionHandler logs the user out of one device. Revoking the session
+// of the request itself logs out the device making it.
+func (s *Server) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid session id")))
+		return
+	}
+
+	err = s.db.DeleteSession(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteOtherSessionsHandler logs the user out of every device but the one
+// making the request, and reports how many sessions were revoked.
+func (s *Server) deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	n, err := s.db.DeleteOtherSessions(r.Context(), sessionIdFromContext(r), userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(map[string]int64{"revoked": n})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Tag colours are optional hex colours such as #1e90ff
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// validateTag trims the tag name and checks that it can be used in a tag
+// filter, where a leading "-" excludes the tag.
+func validateTag(tag *m.NewTag) error {
+	var v validator
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	v.check(tag.Name != "", "name", "is required")
+	v.check(!strings.HasPrefix(tag.Name, "-"), "name", "cannot start with '-'")
+	v.maxLength(tag.Name, "name", maxNameLength)
+	v.check(tag.Color == "" || tagColorPattern.MatchString(tag.Color), "color", "must be a hex colour like #1e90ff")
+
+	return v.err()
+}
+
+// tagFilter splits tag query parameters into required and excluded tags.
+// ?tag=work&tag=-someday matches todos tagged work but not someday.
+func tagFilter(values []string) (include []string, exclude []string) {
+	for _, v := range values {
+		if name, ok := strings.CutPrefix(v, "-"); ok {
+			exclude = append(exclude, name)
+		} else {
+			include = append(include, v)
+		}
+	}
+	return include, exclude
+}
+
+func (s *Server) getTagsHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	tags, err := s.db.GetTags(r.Context(), userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(tags)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) createTagHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	var body m.NewTag
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTag(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	id, err := s.db.CreateTag(r.Context(), body, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(m.Tag{ID: id, Name: body.Name, Color: body.Color})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) editTagHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid tag id")))
+		return
+	}
+
+	var body m.NewTag
+	if err := decodeJSON(w, r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := validateTag(&body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = s.db.EditTag(r.Context(), id, body, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonResp, err := json.Marshal(m.Tag{ID: id, Name: body.Name, Color: body.Color})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	_, _ = w.Write(jsonResp)
+}
+
+func (s *Server) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	userId := userIdFromContext(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, badRequest(errors.New("invalid tag id")))
+		return
+	}
+
+	err = s.db.DeleteTag(r.Context(), id, userId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
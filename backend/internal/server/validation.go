@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Largest request body accepted
+const maxBodyBytes = 1 << 20
+
+// Limits on user input, lengths are in characters
+const (
+	maxTitleLength      = 200
+	maxBodyLength       = 10000
+	maxNameLength       = 50
+	maxTags             = 20
+	maxRecurrenceLength = 500
+)
+
+// Dates outside of these years are almost certainly typos
+const (
+	minYear = 1970
+	maxYear = 2200
+)
+
+// FieldError describes why one field of a request body is not valid.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field of a request body that is not valid.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		if fe.Field == "" {
+			messages[i] = fe.Message
+		} else {
+			messages[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validator collects field errors so a client learns about all of them at once.
+type validator struct {
+	errs ValidationError
+}
+
+// check records message for field unless ok.
+func (v *validator) check(ok bool, field string, message string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Message: message})
+	}
+}
+
+// maxLength checks that value has at most max characters.
+func (v *validator) maxLength(value string, field string, max int) {
+	v.check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// date checks that a date is within a sane range of years.
+func (v *validator) date(t *time.Time, field string) {
+	if t == nil {
+		return
+	}
+	v.check(t.Year() >= minYear && t.Year() <= maxYear, field, fmt.Sprintf("must be between the years %d and %d", minYear, maxYear))
+}
+
+// id checks that an optional reference to another record is a valid id.
+func (v *validator) id(id *int, field string) {
+	v.check(id == nil || *id > 0, field, "must be a positive id")
+}
+
+// err returns the collected errors, or nil when there are none.
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// decodeJSON decodes a request body of at most maxBodyBytes into dst.
+// Unknown fields and trailing data are rejected.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return badRequest(errors.New("request body must contain a single JSON value"))
+	}
+
+	return nil
+}
+
+// decodeError maps an error decoding a request body to the problem it
+// represents: a body that is too large, malformed JSON, or fields with the
+// wrong type or name.
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return payloadTooLarge(fmt.Errorf("request body must be at most %d bytes", maxBytesErr.Limit))
+	case errors.As(err, &typeErr):
+		return ValidationError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}
+	case errors.As(err, &timeErr):
+		return ValidationError{{Message: "dates must be RFC 3339 timestamps"}}
+	case errors.Is(err, m.ErrUnknownPriority):
+		return ValidationError{{Field: "priority", Message: "must be one of none, low, medium, high or urgent"}}
+	}
+
+	// The decoder has no error type for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return ValidationError{{Field: strings.Trim(field, `"`), Message: "is not a known field"}}
+	}
+
+	if errors.Is(err, io.EOF) {
+		return badRequest(errors.New("request body is empty"))
+	}
+	return badRequest(err)
+}
+
+// validateNewTodo trims the title of a created or edited todo and checks
+// every field of it.
+func validateNewTodo(todo *m.NewTodo) error {
+	var v validator
+
+	todo.Title = strings.TrimSpace(todo.Title)
+	v.check(todo.Title != "", "title", "is required")
+	v.maxLength(todo.Title, "title", maxTitleLength)
+	v.maxLength(todo.Description, "body", maxBodyLength)
+
+	v.check(len(todo.Tags) <= maxTags, "tags", fmt.Sprintf("must have at most %d tags", maxTags))
+	for i, tag := range todo.Tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		v.check(strings.TrimSpace(tag) != "", field, "is required")
+		v.check(!strings.HasPrefix(strings.TrimSpace(tag), "-"), field, "cannot start with '-'")
+		v.maxLength(tag, field, maxNameLength)
+	}
+
+	v.id(todo.ListId, "listId")
+	v.id(todo.ParentId, "parentId")
+
+	v.maxLength(todo.Recurrence, "recurrence", maxRecurrenceLength)
+
+	v.date(todo.DueAt, "dueAt")
+	v.date(todo.StartAt, "startAt")
+	v.check(todo.DueAt == nil || todo.StartAt == nil || !todo.StartAt.After(*todo.DueAt), "startAt", "cannot be after dueAt")
+
+	return v.err()
+}
+
+// validateMove checks the neighbours of a moved todo.
+func validateMove(move *m.MoveTodo) error {
+	var v validator
+
+	v.id(move.After, "after")
+	v.id(move.Before, "before")
+
+	return v.err()
+}
+
+// validateParent checks the new parent of a todo, which is nil for a top
+// level todo.
+func validateParent(parentId *int) error {
+	var v validator
+
+	v.id(parentId, "parentId")
+
+	return v.err()
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	m "github.com/raziel-aleman/go-todo-app/internal/models"
+)
+
+// Computed todo views accepted by the view query parameter on /api/todos
+const (
+	viewToday    = "today"
+	viewOverdue  = "overdue"
+	viewUpcoming = "upcoming"
+)
+
+// viewFilter builds the filter for a computed view. Day boundaries are
+// evaluated in the user's location so "today" matches the user's calendar
+// rather than UTC.
+func viewFilter(view string, now time.Time, loc *time.Location) (m.TodoFilter, error) {
+	local := now.In(loc)
+	startOfToday := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	startOfTomorrow := startOfToday.AddDate(0, 0, 1)
+	notDone := false
+
+	switch view {
+	case viewToday:
+		return m.TodoFilter{DueFrom: &startOfToday, DueBefore: &startOfTomorrow, Sort: m.SortDueAt}, nil
+	case viewOverdue:
+		return m.TodoFilter{Done: &notDone, DueBefore: &now, Sort: m.SortDueAt}, nil
+	case viewUpcoming:
+		return m.TodoFilter{Done: &notDone, DueFrom: &startOfTomorrow, Sort: m.SortDueAt}, nil
+	default:
+		return m.TodoFilter{}, fmt.Errorf("unknown view %q", view)
+	}
+}
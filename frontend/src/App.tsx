@@ -54,9 +54,9 @@ export function App() {
 		window.location.href = "http://localhost:8080/auth/logout/github";
 	};
 
-	const markTodoAddDone = async (id: number) => {
-		const updated = await fetch(`${ENDPOINT}/api/todos/${id}/done`, {
-			method: "PATCH",
+	const markTodoAddDone = async (todo: Todo) => {
+		const updated = await fetch(`${ENDPOINT}/api/todos/${todo.id}/done?return=list`, {
+			method: todo.done ? "DELETE" : "PUT",
 			credentials: "include",
 			// headers: {
 			// 	"Cookie": document.cookie.split('; ').filter(row => row.startsWith('session_id=')).map(c=>c.split('=')[1])[0],
@@ -106,7 +106,7 @@ export function App() {
 										textAlign: "left",
 										cursor: "pointer",
 									}}
-									onClick={() => markTodoAddDone(todo.id)}
+									onClick={() => markTodoAddDone(todo)}
 									key={`todo__list__item__${todo.id}`}
 									icon={
 										todo.done ? (
@@ -16,7 +16,7 @@ const AddTodo = ({ mutate }: { mutate: KeyedMutator<Todo[]> }) => {
 	});
 
 	const createTodo = async (values: { title: string; body: string }) => {
-		const updated = await fetch(`${ENDPOINT}/api/todos`, {
+		const updated = await fetch(`${ENDPOINT}/api/todos?return=list`, {
 			method: "POST",
 			credentials: "include",
 			headers: {
@@ -36,7 +36,7 @@ const EditTodo = ({ mutate, data }: EditTodoProps) => {
 	});
 
 	const editTodo = async (values: { title: string; body: string }) => {
-		const edited = await fetch(`${ENDPOINT}/api/todos/${data.id}/edit`, {
+		const edited = await fetch(`${ENDPOINT}/api/todos/${data.id}/edit?return=list`, {
 			method: "PATCH",
 			credentials: "include",
 			headers: {